@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -30,38 +31,26 @@ func main() {
 	}
 
 	notifications := make(chan *apns2.Notification, 100)
-	responses := make(chan *apns2.Response, *count)
 
 	client := apns2.NewClient(cert).Production()
 
-	for i := 0; i < 50; i++ {
-		go worker(client, notifications, responses)
-	}
-
-	for i := 0; i < *count; i++ {
-		n := &apns2.Notification{
-			DeviceToken: *token,
-			Topic:       *topic,
-			Payload:     payload.NewPayload().Alert(fmt.Sprintf("Hello! %v", i)),
+	go func() {
+		for i := 0; i < *count; i++ {
+			notifications <- &apns2.Notification{
+				DeviceToken: *token,
+				Topic:       *topic,
+				Payload:     payload.NewPayload().Alert(fmt.Sprintf("Hello! %v", i)),
+			}
 		}
-		notifications <- n
-	}
-
-	for i := 0; i < *count; i++ {
-		res := <-responses
-		fmt.Printf("%v %v %v\n", res.StatusCode, res.ApnsID, res.Reason)
-	}
-
-	close(notifications)
-	close(responses)
-}
-
-func worker(client *apns2.Client, notifications <-chan *apns2.Notification, responses chan<- *apns2.Response) {
-	for n := range notifications {
-		res, err := client.Push(n)
-		if err != nil {
-			log.Fatal("Push Error:", err)
+		close(notifications)
+	}()
+
+	results := client.PushStream(context.Background(), notifications, &apns2.BatchOptions{Concurrency: 50})
+	for res := range results {
+		if res.Err != nil {
+			log.Println("Push Error:", res.Err)
+			continue
 		}
-		responses <- res
+		fmt.Printf("%v %v %v\n", res.Response.StatusCode, res.Response.ApnsID, res.Response.Reason)
 	}
 }
@@ -0,0 +1,24 @@
+package apns2test_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/sideshow/apns2/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Unit Tests
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	var c clock.Clock = apns2test.NewFakeClock(start)
+	assert.Equal(t, start, c.Now())
+
+	c.(*apns2test.FakeClock).Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), c.Now())
+
+	c.(*apns2test.FakeClock).Set(start)
+	assert.Equal(t, start, c.Now())
+}
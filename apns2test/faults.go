@@ -0,0 +1,265 @@
+package apns2test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sideshow/apns2"
+)
+
+// Rule is a scripted behavior of a Server, which applies to notifications to
+// a device token or topic. Rules apply to notifications which are
+// authenticated, before they are validated.
+type Rule struct {
+	// DeviceToken, if set, limits the rule to notifications to the device
+	// token.
+	DeviceToken string
+
+	// Topic, if set, limits the rule to notifications for the topic.
+	Topic string
+
+	// Rate, if set, is the probability from 0 to 1 that the rule applies to a
+	// matching notification. The rule always applies if Rate is 0.
+	Rate float64
+
+	// Times, if set, is the number of times the rule applies, after which it
+	// is ignored.
+	Times int
+
+	// Latency is the time to wait before responding.
+	Latency time.Duration
+
+	// Reason, if set, is the reason the notification is rejected with.
+	Reason *apns2.ReasonError
+
+	// Timestamp, if set, is the time the device token was last valid, which
+	// is included in the response with Reason.
+	Timestamp time.Time
+
+	// GoAway, if true, sends a GOAWAY frame on the connection after the
+	// response, so that the client makes a new connection.
+	GoAway bool
+
+	// Reset, if true, closes the connection without responding.
+	Reset bool
+}
+
+type ruleState struct {
+	Rule
+	applied int
+}
+
+// Request is a request received by a Server.
+type Request struct {
+	// Time is when the request was received.
+	Time time.Time
+
+	// Connection is the number of the connection the request was received
+	// on, counting from 1.
+	Connection int
+
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+
+	// Notification is the notification sent in the request, or nil if the
+	// request was rejected before the notification was read.
+	Notification *apns2.Notification
+
+	// StatusCode is the status of the response, or 0 if the connection was
+	// reset.
+	StatusCode int
+
+	// Reason is the reason in the response, if any.
+	Reason string
+}
+
+// AddRule adds a rule. Rules are applied in the order they are added, until
+// one rejects the notification.
+func (s *Server) AddRule(rule Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &ruleState{Rule: rule})
+}
+
+// ClearRules removes all rules.
+func (s *Server) ClearRules() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = nil
+}
+
+// SetSeed seeds the random numbers used for the Rate of rules, so that the
+// rules apply to the same notifications each time.
+func (s *Server) SetSeed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.random.Seed(seed)
+}
+
+// SetRateLimit rejects notifications with TooManyRequests once more than n
+// notifications have been sent to the same device token within interval. A
+// limit of 0 disables rate limiting.
+func (s *Server) SetRateLimit(n int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit, s.rateInterval = n, interval
+	s.sent = map[string][]time.Time{}
+}
+
+// SetMaxConcurrentStreams sets the SETTINGS_MAX_CONCURRENT_STREAMS advertised
+// to clients. It is sent in a SETTINGS frame on every open connection, and
+// advertised on new connections. A value of 0 uses the http2 default of 250.
+//
+// The Server does not refuse streams over the limit, so a client which
+// ignores it is not detected.
+func (s *Server) SetMaxConcurrentStreams(n uint32) {
+	s.mu.Lock()
+	s.maxConcurrentStreams = n
+	var conns []*settingsConn
+	for _, sc := range s.conns {
+		if sc != nil {
+			conns = append(conns, sc)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.setMaxConcurrentStreams(n)
+	}
+}
+
+// GoAway sends a GOAWAY frame on every connection. Requests in flight are
+// completed, and clients make new connections for further requests.
+func (s *Server) GoAway() {
+	s.base.Shutdown(context.Background())
+}
+
+// ResetConnections closes every connection without responding to the
+// requests in flight.
+func (s *Server) ResetConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Shutdown makes the Server reject notifications with Shutdown, and send a
+// GOAWAY frame with each response, until Resume is called.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+}
+
+// Resume makes the Server accept notifications after Shutdown.
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = false
+}
+
+// Requests returns the requests received by the Server, in the order they
+// were responded to.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// ClearRequests clears the requests returned by Requests.
+func (s *Server) ClearRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) record(req *Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, *req)
+}
+
+// allow reports whether a notification can be sent to the device token
+// within the rate limit.
+func (s *Server) allow(deviceToken string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rateLimit <= 0 {
+		return true
+	}
+	now := time.Now()
+	sent := s.sent[deviceToken]
+	for len(sent) > 0 && now.Sub(sent[0]) >= s.rateInterval {
+		sent = sent[1:]
+	}
+	if len(sent) >= s.rateLimit {
+		s.sent[deviceToken] = sent
+		return false
+	}
+	s.sent[deviceToken] = append(sent, now)
+	return true
+}
+
+// match returns the rules which apply to the notification.
+func (s *Server) match(n *apns2.Notification) []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rules []Rule
+	for _, rule := range s.rules {
+		if rule.DeviceToken != "" && rule.DeviceToken != n.DeviceToken {
+			continue
+		}
+		if rule.Topic != "" && rule.Topic != n.Topic {
+			continue
+		}
+		if rule.Times > 0 && rule.applied >= rule.Times {
+			continue
+		}
+		if rule.Rate > 0 && s.random.Float64() >= rule.Rate {
+			continue
+		}
+		rule.applied++
+		rules = append(rules, rule.Rule)
+	}
+	return rules
+}
+
+// applyRule applies the rule to the request. It returns true if the request
+// has been responded to.
+func applyRule(w *response, r *http.Request, rule Rule) bool {
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return true
+		case <-timer.C:
+		}
+	}
+	if rule.Reset {
+		if c, ok := r.Context().Value(connKey{}).(*conn); ok {
+			c.Close()
+		}
+		return true
+	}
+	if rule.GoAway {
+		// The http2 server sends a GOAWAY frame for responses with a
+		// "Connection: close" header.
+		w.Header().Set("Connection", "close")
+	}
+	if rule.Reason != nil {
+		var timestamp int64
+		if !rule.Timestamp.IsZero() {
+			timestamp = rule.Timestamp.UnixNano() / int64(time.Millisecond)
+		}
+		writeReason(w, rule.Reason, timestamp)
+		return true
+	}
+	return false
+}
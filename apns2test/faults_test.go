@@ -0,0 +1,306 @@
+package apns2test_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/stretchr/testify/assert"
+)
+
+// Functional Tests
+
+func TestRuleReasonForDeviceToken(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.AddRule(apns2test.Rule{DeviceToken: mockDeviceToken, Reason: apns2.ErrUnregistered, Timestamp: at})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+	assert.Equal(t, apns2.ReasonUnregistered, res.Reason)
+	assert.True(t, at.Equal(res.Timestamp.Time))
+
+	n := mockNotification()
+	n.DeviceToken = "22bb01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"
+	res, err = client.Push(n)
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestRuleReasonForTopic(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Topic: "com.example.other", Reason: apns2.ErrBadTopic})
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestRuleTimes(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Times: 2, Reason: apns2.ErrInternalServerError})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	for i := 0; i < 2; i++ {
+		res, err := client.Push(mockNotification())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	}
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestRuleRate(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.SetSeed(1)
+	s.AddRule(apns2test.Rule{Rate: 0.5, Reason: apns2.ErrServiceUnavailable})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	rejected := 0
+	for i := 0; i < 20; i++ {
+		res, err := client.Push(mockNotification())
+		assert.NoError(t, err)
+		if res.StatusCode == http.StatusServiceUnavailable {
+			rejected++
+		}
+	}
+	assert.True(t, rejected > 0 && rejected < 20)
+}
+
+func TestRuleLatency(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Latency: 50 * time.Millisecond})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+
+	start := time.Now()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.PushWithContext(ctx, mockNotification())
+	assert.Error(t, err)
+}
+
+func TestRuleGoAway(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Times: 1, GoAway: true})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	for i := 0; i < 2; i++ {
+		res, err := client.Push(mockNotification())
+		assert.NoError(t, err)
+		assert.True(t, res.Sent())
+	}
+	requests := s.Requests()
+	assert.Equal(t, 1, requests[0].Connection)
+	assert.Equal(t, 2, requests[1].Connection)
+}
+
+func TestRuleReset(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Times: 1, Reset: true})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+
+	_, err := client.Push(mockNotification())
+	assert.Error(t, err)
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+
+	requests := s.Requests()
+	assert.Equal(t, 0, requests[0].StatusCode)
+	assert.Equal(t, 2, requests[1].Connection)
+}
+
+func TestClearRules(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.AddRule(apns2test.Rule{Reason: apns2.ErrInternalServerError})
+	s.ClearRules()
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestRateLimit(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.SetRateLimit(2, time.Minute)
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	for i := 0; i < 2; i++ {
+		res, err := client.Push(mockNotification())
+		assert.NoError(t, err)
+		assert.True(t, res.Sent())
+	}
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, apns2.ReasonTooManyRequests, res.Reason)
+}
+
+func TestGoAway(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	s.GoAway()
+	time.Sleep(50 * time.Millisecond)
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, 2, s.Requests()[1].Connection)
+}
+
+func TestResetConnections(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	s.ResetConnections()
+	time.Sleep(50 * time.Millisecond)
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, 2, s.Requests()[1].Connection)
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.SetMaxConcurrentStreams(1)
+	s.AddRule(apns2test.Rule{Latency: 100 * time.Millisecond})
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+
+	// The client queues pushes once the single stream is in use.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Push(mockNotification())
+			assert.NoError(t, err)
+			assert.True(t, res.Sent())
+		}()
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	requests := s.Requests()
+	assert.Len(t, requests, 3)
+	assert.Equal(t, requests[1].Connection, requests[2].Connection)
+}
+
+func TestMaxConcurrentStreamsOnLiveConnection(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.SetMaxConcurrentStreams(1)
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.StreamStats().MaxConcurrentStreams)
+
+	pushAll := func(n int) time.Duration {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := client.Push(mockNotification())
+				assert.NoError(t, err)
+				assert.True(t, res.Sent())
+			}()
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+	s.AddRule(apns2test.Rule{Latency: 100 * time.Millisecond})
+
+	// Raising the limit lets the pushes queued behind the single stream go.
+	done := make(chan time.Duration)
+	go func() {
+		done <- pushAll(3)
+	}()
+	assert.Eventually(t, func() bool {
+		return client.StreamStats().Queued == 2
+	}, time.Second, time.Millisecond)
+	s.SetMaxConcurrentStreams(3)
+	assert.True(t, <-done < 250*time.Millisecond)
+	assert.Equal(t, 3, client.StreamStats().MaxConcurrentStreams)
+	assert.True(t, pushAll(3) < 200*time.Millisecond)
+
+	// Lowering the limit queues pushes again.
+	s.SetMaxConcurrentStreams(1)
+	assert.Eventually(t, func() bool {
+		return client.StreamStats().MaxConcurrentStreams == 1
+	}, time.Second, time.Millisecond)
+	assert.True(t, pushAll(2) >= 200*time.Millisecond)
+
+	stats := client.StreamStats()
+	assert.Equal(t, 1, stats.Conns)
+	for _, r := range s.Requests() {
+		assert.Equal(t, 1, r.Connection)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	s.Shutdown()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, apns2.ReasonShutdown, res.Reason)
+
+	s.Resume()
+	res, err = client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, 2, s.Requests()[1].Connection)
+}
+
+func TestRequests(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	n := mockNotification()
+	n.Priority = apns2.PriorityLow
+	_, err := client.Push(n)
+	assert.NoError(t, err)
+	n.DeviceToken = "nothex"
+	_, err = client.Push(n)
+	assert.NoError(t, err)
+
+	requests := s.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPost, requests[0].Method)
+	assert.Equal(t, "/3/device/"+mockDeviceToken, requests[0].Path)
+	assert.Equal(t, "5", requests[0].Header.Get("apns-priority"))
+	assert.Equal(t, `{"aps":{"alert":"Hello!"}}`, string(requests[0].Body))
+	assert.Equal(t, mockDeviceToken, requests[0].Notification.DeviceToken)
+	assert.Equal(t, "com.example.app", requests[0].Notification.Topic)
+	assert.Equal(t, http.StatusOK, requests[0].StatusCode)
+	assert.Equal(t, http.StatusBadRequest, requests[1].StatusCode)
+	assert.Equal(t, apns2.ReasonBadDeviceToken, requests[1].Reason)
+
+	s.ClearRequests()
+	assert.Empty(t, s.Requests())
+}
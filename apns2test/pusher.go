@@ -0,0 +1,207 @@
+package apns2test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sideshow/apns2"
+)
+
+// FakePusher is an apns2.Pusher which records the notifications pushed and
+// returns scripted responses, for unit testing code which sends
+// notifications without a Server. The zero value accepts every notification.
+type FakePusher struct {
+	// Respond, if set, returns the response for notifications with no
+	// response added by AddResponse. If nil, notifications are accepted with
+	// a 200 response.
+	Respond func(n *apns2.Notification) (*apns2.Response, error)
+
+	mu            sync.Mutex
+	notifications []*apns2.Notification
+	responses     []fakeResponse
+}
+
+type fakeResponse struct {
+	res *apns2.Response
+	err error
+}
+
+// NewFakePusher returns a new FakePusher which accepts every notification.
+func NewFakePusher() *FakePusher {
+	return &FakePusher{}
+}
+
+// AddResponse adds a response to return, in order, for the next
+// notification pushed.
+func (f *FakePusher) AddResponse(res *apns2.Response, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, fakeResponse{res, err})
+}
+
+// AddReason adds a response rejecting the next notification pushed with the
+// reason.
+func (f *FakePusher) AddReason(reason *apns2.ReasonError) {
+	f.AddResponse(&apns2.Response{StatusCode: reason.StatusCode, Reason: reason.Reason}, nil)
+}
+
+// PushWithContext implements apns2.Pusher. It records the notification and
+// returns the next response added by AddResponse, or the response from
+// Respond. It returns the context's error if the context is done.
+func (f *FakePusher) PushWithContext(ctx apns2.Context, n *apns2.Notification) (*apns2.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.notifications = append(f.notifications, n)
+	if len(f.responses) > 0 {
+		r := f.responses[0]
+		f.responses = f.responses[1:]
+		f.mu.Unlock()
+		return r.res, r.err
+	}
+	f.mu.Unlock()
+
+	if f.Respond != nil {
+		return f.Respond(n)
+	}
+	apnsID := n.ApnsID
+	if apnsID == "" {
+		apnsID = newUUID()
+	}
+	return &apns2.Response{StatusCode: http.StatusOK, ApnsID: apnsID, ApnsUniqueID: newUUID()}, nil
+}
+
+// Notifications returns the notifications pushed, in order.
+func (f *FakePusher) Notifications() []*apns2.Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	notifications := make([]*apns2.Notification, len(f.notifications))
+	copy(notifications, f.notifications)
+	return notifications
+}
+
+// Topics returns the topics of the notifications pushed, in order.
+func (f *FakePusher) Topics() []string {
+	var topics []string
+	for _, n := range f.Notifications() {
+		topics = append(topics, n.Topic)
+	}
+	return topics
+}
+
+// DeviceTokens returns the device tokens of the notifications pushed, in
+// order.
+func (f *FakePusher) DeviceTokens() []string {
+	var tokens []string
+	for _, n := range f.Notifications() {
+		tokens = append(tokens, n.DeviceToken)
+	}
+	return tokens
+}
+
+// Payloads returns the payloads of the notifications pushed, in order,
+// decoded from JSON.
+func (f *FakePusher) Payloads() ([]interface{}, error) {
+	var payloads []interface{}
+	for _, n := range f.Notifications() {
+		p, err := decodePayload(n)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, p)
+	}
+	return payloads, nil
+}
+
+// Reset clears the notifications pushed and the responses added.
+func (f *FakePusher) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notifications = nil
+	f.responses = nil
+}
+
+// AssertCount checks that count notifications were pushed.
+func (f *FakePusher) AssertCount(t testing.TB, count int) bool {
+	t.Helper()
+	if n := len(f.Notifications()); n != count {
+		t.Errorf("apns2test: %d notifications pushed, want %d", n, count)
+		return false
+	}
+	return true
+}
+
+// AssertTopics checks that notifications were pushed for the topics, in
+// order.
+func (f *FakePusher) AssertTopics(t testing.TB, topics ...string) bool {
+	t.Helper()
+	if got := f.Topics(); !equalStrings(got, topics) {
+		t.Errorf("apns2test: notifications pushed for topics %q, want %q", got, topics)
+		return false
+	}
+	return true
+}
+
+// AssertDeviceTokens checks that notifications were pushed to the device
+// tokens, in order.
+func (f *FakePusher) AssertDeviceTokens(t testing.TB, tokens ...string) bool {
+	t.Helper()
+	if got := f.DeviceTokens(); !equalStrings(got, tokens) {
+		t.Errorf("apns2test: notifications pushed to device tokens %q, want %q", got, tokens)
+		return false
+	}
+	return true
+}
+
+// AssertPayload checks that the payload of the i-th notification pushed is
+// equal to the JSON payload, ignoring formatting and the order of keys.
+func (f *FakePusher) AssertPayload(t testing.TB, i int, payload string) bool {
+	t.Helper()
+	notifications := f.Notifications()
+	if i < 0 || i >= len(notifications) {
+		t.Errorf("apns2test: %d notifications pushed, no notification %d", len(notifications), i)
+		return false
+	}
+	var want interface{}
+	if err := json.Unmarshal([]byte(payload), &want); err != nil {
+		t.Errorf("apns2test: invalid expected payload: %v", err)
+		return false
+	}
+	got, err := decodePayload(notifications[i])
+	if err != nil {
+		t.Errorf("apns2test: invalid payload in notification %d: %v", i, err)
+		return false
+	}
+	if !reflect.DeepEqual(got, want) {
+		b, _ := json.Marshal(got)
+		t.Errorf("apns2test: notification %d has payload %s, want %s", i, b, payload)
+		return false
+	}
+	return true
+}
+
+func decodePayload(n *apns2.Notification) (interface{}, error) {
+	b, err := n.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var p interface{}
+	err = json.Unmarshal(b, &p)
+	return p, err
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
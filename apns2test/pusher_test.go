@@ -0,0 +1,113 @@
+package apns2test_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/sideshow/apns2/payload"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+type mockT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *mockT) Helper() {}
+
+func (t *mockT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, format)
+}
+
+// Unit Tests
+
+func TestFakePusherAccepts(t *testing.T) {
+	var pusher apns2.Pusher = apns2test.NewFakePusher()
+	n := mockNotification()
+	n.ApnsID = "84DB694F-464F-49BD-960A-D6DB028335C9"
+	res, err := pusher.PushWithContext(context.Background(), n)
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, n.ApnsID, res.ApnsID)
+}
+
+func TestFakePusherScriptedResponses(t *testing.T) {
+	f := &apns2test.FakePusher{}
+	f.AddReason(apns2.ErrBadDeviceToken)
+	f.AddResponse(nil, errors.New("connection reset"))
+
+	res, err := f.PushWithContext(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, apns2.ReasonBadDeviceToken, res.Reason)
+	_, err = f.PushWithContext(context.Background(), mockNotification())
+	assert.EqualError(t, err, "connection reset")
+	res, err = f.PushWithContext(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestFakePusherRespond(t *testing.T) {
+	f := apns2test.NewFakePusher()
+	f.Respond = func(n *apns2.Notification) (*apns2.Response, error) {
+		return &apns2.Response{StatusCode: http.StatusGone, Reason: apns2.ReasonUnregistered}, nil
+	}
+	res, err := f.PushWithContext(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns2.ReasonUnregistered, res.Reason)
+}
+
+func TestFakePusherContextDone(t *testing.T) {
+	f := apns2test.NewFakePusher()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := f.PushWithContext(ctx, mockNotification())
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, f.Notifications())
+}
+
+func TestFakePusherRecords(t *testing.T) {
+	f := apns2test.NewFakePusher()
+	first := mockNotification()
+	second := mockNotification()
+	second.Topic = "com.example.other"
+	second.DeviceToken = "22bb"
+	second.Payload = payload.NewPayload().Alert("Hi").Badge(1)
+	f.PushWithContext(context.Background(), first)
+	f.PushWithContext(context.Background(), second)
+
+	assert.Equal(t, []*apns2.Notification{first, second}, f.Notifications())
+	assert.Equal(t, []string{"com.example.app", "com.example.other"}, f.Topics())
+	assert.Equal(t, []string{mockDeviceToken, "22bb"}, f.DeviceTokens())
+	payloads, err := f.Payloads()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"aps": map[string]interface{}{"alert": "Hi", "badge": float64(1)}}, payloads[1])
+
+	assert.True(t, f.AssertCount(t, 2))
+	assert.True(t, f.AssertTopics(t, "com.example.app", "com.example.other"))
+	assert.True(t, f.AssertDeviceTokens(t, mockDeviceToken, "22bb"))
+	assert.True(t, f.AssertPayload(t, 0, `{ "aps": { "alert": "Hello!" } }`))
+	assert.True(t, f.AssertPayload(t, 1, `{"aps":{"badge":1,"alert":"Hi"}}`))
+
+	f.Reset()
+	assert.Empty(t, f.Notifications())
+}
+
+func TestFakePusherAssertionsFail(t *testing.T) {
+	f := apns2test.NewFakePusher()
+	f.PushWithContext(context.Background(), mockNotification())
+
+	mt := &mockT{}
+	assert.False(t, f.AssertCount(mt, 2))
+	assert.False(t, f.AssertTopics(mt, "com.example.other"))
+	assert.False(t, f.AssertDeviceTokens(mt))
+	assert.False(t, f.AssertPayload(mt, 0, `{"aps":{"alert":"Bye!"}}`))
+	assert.False(t, f.AssertPayload(mt, 1, `{}`))
+	assert.Len(t, mt.errors, 5)
+}
@@ -0,0 +1,319 @@
+package apns2test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/sideshow/apns2"
+)
+
+// RedactedBearer replaces the bearer token in recorded authorization headers.
+const RedactedBearer = "bearer REDACTED"
+
+// DefaultIgnoredHeaders are the request headers a Replayer does not compare by
+// default, because they usually change between runs.
+var DefaultIgnoredHeaders = []string{"apns-id", "apns-expiration"}
+
+// ErrNoInteraction is returned by a Replayer when no recorded interaction
+// matches a request.
+var ErrNoInteraction = errors.New("apns2test: no recorded interaction matches the request")
+
+// Headers which are not recorded, because they are set by the transport.
+var skippedHeaders = map[string]bool{
+	"accept-encoding": true,
+	"content-length":  true,
+	"user-agent":      true,
+}
+
+// Interaction is a request to the APNs and its response, as recorded by a
+// Recorder. Interactions are stored as JSON, one per line. Device tokens and
+// bearer tokens are redacted.
+type Interaction struct {
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	Header         map[string]string `json:"header,omitempty"`
+	Body           string            `json:"body,omitempty"`
+	StatusCode     int               `json:"status"`
+	ResponseHeader map[string]string `json:"response_header,omitempty"`
+	ResponseBody   string            `json:"response_body,omitempty"`
+}
+
+// RedactDeviceToken returns the redacted form of a device token used in
+// recorded interactions, which is the prefix of its SHA-256 hash.
+func RedactDeviceToken(deviceToken string) string {
+	sum := sha256.Sum256([]byte(deviceToken))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// Recorder is an http.RoundTripper which records the requests sent through
+// it, and their responses, to a writer. Use RecordClient to record the
+// interactions of a Client.
+type Recorder struct {
+	// Transport is the transport used to send the requests.
+	Transport http.RoundTripper
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a new Recorder which sends requests with transport, and
+// writes the interactions to w.
+func NewRecorder(transport http.RoundTripper, w io.Writer) *Recorder {
+	return &Recorder{Transport: transport, enc: json.NewEncoder(w)}
+}
+
+// RecordClient makes the Client record its interactions with the APNs to w,
+// and returns the Recorder. The Client keeps using its ConnPool, so pushes are
+// still limited by the streams advertised by the APNs.
+func RecordClient(c *apns2.Client, w io.Writer) *Recorder {
+	r := NewRecorder(c.HTTPClient.Transport, w)
+	c.HTTPClient.Transport = r
+	return r
+}
+
+// RoundTrip implements http.RoundTripper. Requests which fail are not
+// recorded.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	res, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	interaction := newInteraction(req, body)
+	interaction.StatusCode = res.StatusCode
+	interaction.ResponseHeader = recordHeader(res.Header)
+	interaction.ResponseBody = string(resBody)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(interaction); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Unwrap returns the Transport, so that a Client recording its interactions
+// still finds its ConnPool.
+func (r *Recorder) Unwrap() http.RoundTripper {
+	return r.Transport
+}
+
+// CloseIdleConnections closes the idle connections of the Transport, so
+// that Client.CloseIdleConnections works while recording.
+func (r *Recorder) CloseIdleConnections() {
+	if t, ok := r.Transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// Replayer is an http.RoundTripper which responds to requests with recorded
+// interactions, without connecting to the APNs. Each interaction is used
+// once, for the first request which matches it. A request matches if its
+// method, redacted path, headers and body are the same as the recorded
+// request. JSON bodies are compared ignoring formatting and the order of
+// keys. Requests which match no interaction fail with an error wrapping
+// ErrNoInteraction.
+type Replayer struct {
+	// IgnoreHeaders are the request headers which are not compared.
+	IgnoreHeaders []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer returns a new Replayer for the interactions.
+func NewReplayer(interactions []Interaction) *Replayer {
+	return &Replayer{
+		IgnoreHeaders: append([]string(nil), DefaultIgnoredHeaders...),
+		interactions:  interactions,
+		used:          make([]bool, len(interactions)),
+	}
+}
+
+// NewReplayerFromFile returns a new Replayer for the interactions recorded in
+// the file.
+func NewReplayerFromFile(filename string) (*Replayer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	interactions, err := ReadInteractions(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(interactions), nil
+}
+
+// ReplayClient makes the Client respond to notifications with the
+// interactions recorded by the Replayer.
+func ReplayClient(c *apns2.Client, p *Replayer) {
+	c.HTTPClient.Transport = p
+}
+
+// ReadInteractions reads interactions written by a Recorder.
+func ReadInteractions(r io.Reader) ([]Interaction, error) {
+	var interactions []Interaction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(line, &interaction); err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions, scanner.Err()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	got := newInteraction(req, body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, want := range p.interactions {
+		if p.used[i] || !p.matches(got, want) {
+			continue
+		}
+		p.used[i] = true
+		return replayResponse(req, want), nil
+	}
+	return nil, fmt.Errorf("%w: %s %s %s", ErrNoInteraction, got.Method, got.Path, got.Body)
+}
+
+// Unused returns the interactions which have not been replayed.
+func (p *Replayer) Unused() []Interaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var unused []Interaction
+	for i, interaction := range p.interactions {
+		if !p.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+func (p *Replayer) matches(got, want Interaction) bool {
+	if got.Method != want.Method || got.Path != want.Path || !equalBodies(got.Body, want.Body) {
+		return false
+	}
+	ignored := map[string]bool{}
+	for _, name := range p.IgnoreHeaders {
+		ignored[strings.ToLower(name)] = true
+	}
+	for name, value := range got.Header {
+		if !ignored[name] && want.Header[name] != value {
+			return false
+		}
+	}
+	for name := range want.Header {
+		if _, ok := got.Header[name]; !ok && !ignored[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func newInteraction(req *http.Request, body []byte) Interaction {
+	path := req.URL.Path
+	if deviceToken := strings.TrimPrefix(path, "/3/device/"); deviceToken != path {
+		path = "/3/device/" + RedactDeviceToken(deviceToken)
+	}
+	header := recordHeader(req.Header)
+	if _, ok := header["authorization"]; ok {
+		header["authorization"] = RedactedBearer
+	}
+	return Interaction{
+		Method: req.Method,
+		Path:   path,
+		Header: header,
+		Body:   string(body),
+	}
+}
+
+func recordHeader(h http.Header) map[string]string {
+	header := map[string]string{}
+	for name := range h {
+		name = strings.ToLower(name)
+		if !skippedHeaders[name] {
+			header[name] = h.Get(name)
+		}
+	}
+	return header
+}
+
+func replayResponse(req *http.Request, interaction Interaction) *http.Response {
+	header := http.Header{}
+	for name, value := range interaction.ResponseHeader {
+		header.Set(name, value)
+	}
+	// The APNs responds with the apns-id of the request, which may differ
+	// from the recorded one.
+	if apnsID := req.Header.Get("apns-id"); apnsID != "" {
+		header.Set("apns-id", apnsID)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}
+}
+
+func equalBodies(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var x, y interface{}
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,160 @@
+package apns2test_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/stretchr/testify/assert"
+)
+
+// Unit Tests
+
+func TestRedactDeviceToken(t *testing.T) {
+	sum := sha256.Sum256([]byte(mockDeviceToken))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:8]), apns2test.RedactDeviceToken(mockDeviceToken))
+}
+
+func TestReadInteractions(t *testing.T) {
+	interactions, err := apns2test.ReadInteractions(strings.NewReader(`{"method":"POST","path":"/3/device/a","status":200}
+
+{"method":"POST","path":"/3/device/b","status":410,"response_body":"{\"reason\":\"Unregistered\"}"}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []apns2test.Interaction{
+		{Method: "POST", Path: "/3/device/a", StatusCode: 200},
+		{Method: "POST", Path: "/3/device/b", StatusCode: 410, ResponseBody: `{"reason":"Unregistered"}`},
+	}, interactions)
+}
+
+func TestReadInteractionsInvalid(t *testing.T) {
+	_, err := apns2test.ReadInteractions(strings.NewReader("{"))
+	assert.Error(t, err)
+}
+
+// Functional Tests
+
+func TestRecordAndReplay(t *testing.T) {
+	s := apns2test.NewServer()
+	tok := mockToken(t)
+	s.AddAuthKey(tok.KeyID, &tok.AuthKey.PublicKey)
+	s.Unregister("22bb", time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	var recording bytes.Buffer
+	client := s.TokenClient(tok)
+	apns2test.RecordClient(client, &recording)
+	gone := mockNotification()
+	gone.DeviceToken = "22bb"
+	gone.Priority = apns2.PriorityLow
+	for _, n := range []*apns2.Notification{mockNotification(), gone} {
+		_, err := client.Push(n)
+		assert.NoError(t, err)
+	}
+	client.CloseIdleConnections()
+	s.Close()
+
+	assert.NotContains(t, recording.String(), mockDeviceToken)
+	assert.NotContains(t, recording.String(), tok.Bearer)
+	interactions, err := apns2test.ReadInteractions(&recording)
+	assert.NoError(t, err)
+	assert.Len(t, interactions, 2)
+	assert.Equal(t, "/3/device/"+apns2test.RedactDeviceToken(mockDeviceToken), interactions[0].Path)
+	assert.Equal(t, apns2test.RedactedBearer, interactions[0].Header["authorization"])
+	assert.Equal(t, "com.example.app", interactions[0].Header["apns-topic"])
+
+	replayer := apns2test.NewReplayer(interactions)
+	client = apns2.NewTokenClient(mockToken(t))
+	apns2test.ReplayClient(client, replayer)
+
+	n := mockNotification()
+	n.ApnsID = "84DB694F-464F-49BD-960A-D6DB028335C9"
+	n.Payload = []byte(`{ "aps": { "alert": "Hello!" } }`)
+	res, err := client.Push(n)
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, n.ApnsID, res.ApnsID)
+
+	res, err = client.Push(gone)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+	assert.Equal(t, apns2.ReasonUnregistered, res.Reason)
+	assert.True(t, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC).Equal(res.Timestamp.Time))
+	assert.Empty(t, replayer.Unused())
+
+	_, err = client.Push(gone)
+	assert.True(t, errors.Is(err, apns2test.ErrNoInteraction))
+}
+
+func TestRecordClientKeepsConnPool(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	s.SetMaxConcurrentStreams(2)
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	var recording bytes.Buffer
+	apns2test.RecordClient(client, &recording)
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	stats := client.StreamStats()
+	assert.Equal(t, 1, stats.Conns)
+	assert.Equal(t, 2, stats.MaxConcurrentStreams)
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replayer := apns2test.NewReplayer([]apns2test.Interaction{{
+		Method:     http.MethodPost,
+		Path:       "/3/device/" + apns2test.RedactDeviceToken(mockDeviceToken),
+		Header:     map[string]string{"apns-topic": "com.example.app", "apns-push-type": "alert", "content-type": "application/json; charset=utf-8"},
+		Body:       `{"aps":{"alert":"Hello!"}}`,
+		StatusCode: http.StatusOK,
+	}})
+	client := apns2.NewClient(mockCertificate(t, "com.example.app"))
+	apns2test.ReplayClient(client, replayer)
+
+	n := mockNotification()
+	n.Payload = []byte(`{"aps":{"alert":"Goodbye!"}}`)
+	_, err := client.Push(n)
+	assert.True(t, errors.Is(err, apns2test.ErrNoInteraction))
+
+	n = mockNotification()
+	n.CollapseID = "collapse"
+	_, err = client.Push(n)
+	assert.True(t, errors.Is(err, apns2test.ErrNoInteraction))
+
+	n = mockNotification()
+	n.Topic = "com.example.other"
+	_, err = client.Push(n)
+	assert.True(t, errors.Is(err, apns2test.ErrNoInteraction))
+	assert.Len(t, replayer.Unused(), 1)
+
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestReplayFromFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "interactions.jsonl")
+	line := `{"method":"POST","path":"/3/device/` + apns2test.RedactDeviceToken(mockDeviceToken) +
+		`","header":{"apns-topic":"com.example.app","apns-push-type":"alert"},"body":"{\"aps\":{\"alert\":\"Hello!\"}}","status":400,"response_body":"{\"reason\":\"BadDeviceToken\"}"}`
+	assert.NoError(t, os.WriteFile(filename, []byte(line+"\n"), 0600))
+
+	replayer, err := apns2test.NewReplayerFromFile(filename)
+	assert.NoError(t, err)
+	replayer.IgnoreHeaders = append(replayer.IgnoreHeaders, "content-type")
+	client := apns2.NewClient(mockCertificate(t, "com.example.app"))
+	apns2test.ReplayClient(client, replayer)
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns2.ReasonBadDeviceToken, res.Reason)
+
+	_, err = apns2test.NewReplayerFromFile(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.Error(t, err)
+}
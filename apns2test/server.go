@@ -0,0 +1,528 @@
+// Package apns2test provides a mock APNs server for testing code which sends
+// notifications with apns2, without connecting to Apple.
+package apns2test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/certificate"
+	"github.com/sideshow/apns2/token"
+	"golang.org/x/net/http2"
+)
+
+// TokenTimeout is the age after which the Server rejects provider tokens with
+// ExpiredProviderToken.
+var TokenTimeout = time.Hour
+
+// Server is a mock APNs server which speaks the APNs provider API over
+// HTTP/2 and TLS on a local port. Like the APNs, it checks the request headers,
+// verifies provider tokens against the keys added with AddAuthKey, checks
+// client certificates, and responds with an apns-id and, for rejected
+// notifications, a JSON body with the reason.
+//
+// Faults can be injected with AddRule, SetRateLimit,
+// SetMaxConcurrentStreams, GoAway, ResetConnections and Shutdown,
+// and the requests received are recorded for assertions. See Requests.
+//
+// Use Client or TokenClient to create a Client which sends notifications to
+// the Server.
+type Server struct {
+	// URL is the base URL of the server, of the form https://ipaddr:port,
+	// for use as the Host of a Client.
+	URL string
+
+	// TeamID, if set, is the Team ID required in provider tokens.
+	TeamID string
+
+	// ClientCAs, if set, is used to verify client certificates. Requests
+	// using certificates not signed by ClientCAs are rejected with
+	// BadCertificate.
+	ClientCAs *x509.CertPool
+
+	// Certificate is the certificate the Server uses for TLS.
+	Certificate *x509.Certificate
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	h2        *http2.Server
+	base      *http.Server
+
+	mu                   sync.Mutex
+	authKeys             map[string]*ecdsa.PublicKey
+	unregistered         map[string]time.Time
+	rules                []*ruleState
+	random               *mathrand.Rand
+	rateLimit            int
+	rateInterval         time.Duration
+	sent                 map[string][]time.Time
+	maxConcurrentStreams uint32
+	shutdown             bool
+	requests             []Request
+	conns                map[net.Conn]*settingsConn
+	connections          int
+	closed               bool
+	wg                   sync.WaitGroup
+}
+
+type connKey struct{}
+
+// conn is the connection a request was received on.
+type conn struct {
+	net.Conn
+	id int
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server but doesn't start it. After
+// changing its configuration, the caller should call Start.
+func NewUnstartedServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("apns2test: failed to listen on a port: %v", err))
+	}
+	cert, leaf, err := newServerCertificate()
+	if err != nil {
+		panic(fmt.Sprintf("apns2test: failed to create certificate: %v", err))
+	}
+	// The http.Server is only used to send a GOAWAY on every connection,
+	// through Shutdown.
+	h2, base := &http2.Server{}, &http.Server{}
+	if err := http2.ConfigureServer(base, h2); err != nil {
+		panic(fmt.Sprintf("apns2test: failed to configure server: %v", err))
+	}
+	return &Server{
+		Certificate: leaf,
+		listener:    l,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequestClientCert,
+			NextProtos:   []string{http2.NextProtoTLS},
+		},
+		h2:           h2,
+		base:         base,
+		authKeys:     map[string]*ecdsa.PublicKey{},
+		unregistered: map[string]time.Time{},
+		random:       mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+		sent:         map[string][]time.Time{},
+		conns:        map[net.Conn]*settingsConn{},
+	}
+}
+
+// Start starts a server from NewUnstartedServer.
+func (s *Server) Start() {
+	if s.URL != "" {
+		panic("apns2test: Server already started")
+	}
+	s.URL = "https://" + s.listener.Addr().String()
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Close shuts down the server, closing all connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.listener.Close()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// RootCAs returns a certificate pool which trusts the Server's certificate.
+func (s *Server) RootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate)
+	return pool
+}
+
+// Client returns a new certificate Client which sends notifications to the
+// Server.
+func (s *Server) Client(cert tls.Certificate) *apns2.Client {
+	c := apns2.NewClient(cert)
+	c.Host = s.URL
+	c.HTTPClient.Transport.(*http2.Transport).TLSClientConfig.RootCAs = s.RootCAs()
+	return c
+}
+
+// TokenClient returns a new token Client which sends notifications to the
+// Server.
+func (s *Server) TokenClient(t *token.Token) *apns2.Client {
+	c := apns2.NewTokenClient(t)
+	c.Host = s.URL
+	c.HTTPClient.Transport.(*http2.Transport).TLSClientConfig = &tls.Config{RootCAs: s.RootCAs()}
+	return c
+}
+
+// AddAuthKey adds the public key of a signing key, which is used to verify
+// provider tokens with the Key ID.
+func (s *Server) AddAuthKey(keyID string, key *ecdsa.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authKeys[keyID] = key
+}
+
+// Unregister marks the device token as no longer active for its topic, so
+// that notifications to it are rejected with Unregistered and the timestamp.
+func (s *Server) Unregister(deviceToken string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unregistered[deviceToken] = at
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = nil
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	tlsConn := tls.Server(c, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.connections++
+	ctx := context.WithValue(context.Background(), connKey{}, &conn{c, s.connections})
+	sc := newSettingsConn(tlsConn, s.maxConcurrentStreams)
+	s.conns[c] = sc
+	s.mu.Unlock()
+
+	// The stream limit is advertised by the settingsConn, so that it can be
+	// changed on live connections. The http2 server advertises no limit of
+	// its own, and so never refuses a stream.
+	h2 := *s.h2
+	h2.MaxConcurrentStreams = math.MaxUint32
+	h2.ServeConn(sc, &http2.ServeConnOpts{
+		Context:    ctx,
+		BaseConfig: s.base,
+		Handler:    http.HandlerFunc(s.handle),
+	})
+}
+
+func (s *Server) handle(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, apns2.MaxVOIPPayloadSize+1))
+	req := &Request{
+		Time:   time.Now(),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+	if c, ok := r.Context().Value(connKey{}).(*conn); ok {
+		req.Connection = c.id
+	}
+	defer s.record(req)
+	w := &response{ResponseWriter: rw, request: req}
+
+	apnsID := r.Header.Get("apns-id")
+	if apnsID == "" {
+		apnsID = newUUID()
+	}
+	w.Header().Set("apns-id", apnsID)
+
+	if err != nil {
+		writeReason(w, err, 0)
+		return
+	}
+	s.mu.Lock()
+	shutdown := s.shutdown
+	s.mu.Unlock()
+	if shutdown {
+		w.Header().Set("Connection", "close")
+		writeReason(w, apns2.ErrShutdown, 0)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeReason(w, apns2.ErrMethodNotAllowed, 0)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/3/device/") {
+		writeReason(w, apns2.ErrBadPath, 0)
+		return
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "apns-") && len(values) > 1 {
+			writeReason(w, apns2.ErrDuplicateHeaders, 0)
+			return
+		}
+	}
+
+	topics, err := s.authenticate(r)
+	if err != nil {
+		writeReason(w, err, 0)
+		return
+	}
+
+	n, err := notificationFromRequest(r, body)
+	if err != nil {
+		writeReason(w, err, 0)
+		return
+	}
+	if n.Topic == "" {
+		if len(topics) == 0 {
+			writeReason(w, apns2.ErrMissingTopic, 0)
+			return
+		}
+		n.Topic = topics[0]
+	}
+	req.Notification = n
+	if len(topics) > 0 && !containsString(topics, n.Topic) {
+		writeReason(w, apns2.ErrTopicDisallowed, 0)
+		return
+	}
+	if !s.allow(n.DeviceToken) {
+		writeReason(w, apns2.ErrTooManyRequests, 0)
+		return
+	}
+	for _, rule := range s.match(n) {
+		if applyRule(w, r, rule) {
+			return
+		}
+	}
+	if err := n.Validate(); err != nil {
+		writeReason(w, err, 0)
+		return
+	}
+
+	s.mu.Lock()
+	unregistered, ok := s.unregistered[n.DeviceToken]
+	s.mu.Unlock()
+	if ok {
+		writeReason(w, apns2.ErrUnregistered, unregistered.UnixNano()/int64(time.Millisecond))
+		return
+	}
+
+	w.Header().Set("apns-unique-id", newUUID())
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticate checks the provider token or client certificate of the
+// request. For certificate requests, it returns the topics of the
+// certificate, and for token requests it returns nil.
+func (s *Server) authenticate(r *http.Request) ([]string, error) {
+	if authorization := r.Header.Get("authorization"); authorization != "" {
+		return nil, s.verifyToken(strings.TrimPrefix(authorization, "bearer "))
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, apns2.ErrMissingProviderToken
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	if s.ClientCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         s.ClientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, apns2.ErrBadCertificate
+		}
+	}
+	info, err := certificate.Inspect(tls.Certificate{Certificate: [][]byte{leaf.Raw}, Leaf: leaf})
+	if err != nil {
+		return nil, apns2.ErrBadCertificate
+	}
+	topics := []string{}
+	for _, topic := range info.Topics {
+		topics = append(topics, topic.Name)
+	}
+	return topics, nil
+}
+
+func (s *Server) verifyToken(bearer string) error {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(bearer, claims, func(t *jwt.Token) (interface{}, error) {
+		keyID, _ := t.Header["kid"].(string)
+		s.mu.Lock()
+		key, ok := s.authKeys[keyID]
+		s.mu.Unlock()
+		if !ok {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	})
+	if err != nil {
+		return apns2.ErrInvalidProviderToken
+	}
+	if teamID, _ := claims["iss"].(string); s.TeamID != "" && teamID != s.TeamID {
+		return apns2.ErrInvalidProviderToken
+	}
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return apns2.ErrInvalidProviderToken
+	}
+	if time.Since(time.Unix(int64(iat), 0)) > TokenTimeout {
+		return apns2.ErrExpiredProviderToken
+	}
+	return nil
+}
+
+// notificationFromRequest returns the notification sent in the request, with
+// the body already read from it.
+func notificationFromRequest(r *http.Request, body []byte) (*apns2.Notification, error) {
+	n := &apns2.Notification{
+		DeviceToken: strings.TrimPrefix(r.URL.Path, "/3/device/"),
+		Topic:       r.Header.Get("apns-topic"),
+		ApnsID:      r.Header.Get("apns-id"),
+		CollapseID:  r.Header.Get("apns-collapse-id"),
+		PushType:    apns2.EPushType(r.Header.Get("apns-push-type")),
+	}
+	if priority := r.Header.Get("apns-priority"); priority != "" {
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return nil, apns2.ErrBadPriority
+		}
+		n.Priority = p
+	}
+	if expiration := r.Header.Get("apns-expiration"); expiration != "" {
+		e, err := strconv.ParseInt(expiration, 10, 64)
+		if err != nil {
+			return nil, apns2.ErrBadExpirationDate
+		}
+		n.Expiration = time.Unix(e, 0)
+	}
+	// The body is read up to one byte past the largest payload, so a longer
+	// body is truncated and must be rejected before it is parsed.
+	if len(body) > apns2.MaxVOIPPayloadSize {
+		return nil, apns2.ErrPayloadTooLarge
+	}
+	// The APNs does not document a reason for payloads which are not JSON,
+	// so they are treated as empty.
+	if len(body) > 0 && !json.Valid(body) {
+		return nil, apns2.ErrPayloadEmpty
+	}
+	n.Payload = body
+	return n, nil
+}
+
+// response records the status and reason written to a request.
+type response struct {
+	http.ResponseWriter
+	request *Request
+}
+
+func (w *response) WriteHeader(statusCode int) {
+	w.request.StatusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// writeReason writes a response rejecting the notification with the reason
+// of err, which is a *apns2.ReasonError or wraps one. Other errors are
+// written as a 500 InternalServerError.
+func writeReason(w *response, err error, timestamp int64) {
+	reason := apns2.ErrInternalServerError
+	errors.As(err, &reason)
+	w.request.Reason = reason.Reason
+	body := map[string]interface{}{"reason": reason.Reason}
+	if timestamp != 0 {
+		body["timestamp"] = timestamp
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(reason.StatusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newServerCertificate() (tls.Certificate, *x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "apns2test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, leaf, nil
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package apns2test_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+const mockDeviceToken = "11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"
+
+func mockCertificate(t *testing.T, topic string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "Apple Push Services: " + topic,
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: topic},
+			},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func mockToken(t *testing.T) *token.Token {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return &token.Token{AuthKey: key, KeyID: "ABC123DEFG", TeamID: "DEF123GHIJ"}
+}
+
+func mockNotification() *apns2.Notification {
+	return &apns2.Notification{
+		DeviceToken: mockDeviceToken,
+		Topic:       "com.example.app",
+		Payload:     []byte(`{"aps":{"alert":"Hello!"}}`),
+	}
+}
+
+// Functional Tests
+
+func TestCertificatePush(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, res.Sent())
+	assert.Len(t, res.ApnsID, 36)
+	assert.Len(t, res.ApnsUniqueID, 36)
+}
+
+func TestCertificatePushDefaultTopic(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	n := mockNotification()
+	n.Topic = ""
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(n)
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestPushReturnsApnsID(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	n := mockNotification()
+	n.ApnsID = "84DB694F-464F-49BD-960A-D6DB028335C9"
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(n)
+	assert.NoError(t, err)
+	assert.Equal(t, n.ApnsID, res.ApnsID)
+}
+
+func TestCertificateTopicDisallowed(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	n := mockNotification()
+	n.Topic = "com.example.other"
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(n)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, apns2.ReasonTopicDisallowed, res.Reason)
+}
+
+func TestCertificateNotSignedByClientCAs(t *testing.T) {
+	s := apns2test.NewUnstartedServer()
+	s.ClientCAs = x509.NewCertPool()
+	s.Start()
+	defer s.Close()
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, apns2.ReasonBadCertificate, res.Reason)
+}
+
+func TestMissingProviderToken(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	res, err := s.Client(tls.Certificate{}).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, apns2.ReasonMissingProviderToken, res.Reason)
+}
+
+func TestTokenPush(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	tok := mockToken(t)
+	s.AddAuthKey(tok.KeyID, &tok.AuthKey.PublicKey)
+	res, err := s.TokenClient(tok).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestTokenPushMissingTopic(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	tok := mockToken(t)
+	s.AddAuthKey(tok.KeyID, &tok.AuthKey.PublicKey)
+	n := mockNotification()
+	n.Topic = ""
+	res, err := s.TokenClient(tok).Push(n)
+	assert.NoError(t, err)
+	assert.Equal(t, apns2.ReasonMissingTopic, res.Reason)
+}
+
+func TestTokenPushUnknownKey(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	res, err := s.TokenClient(mockToken(t)).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, apns2.ReasonInvalidProviderToken, res.Reason)
+}
+
+func TestTokenPushWrongTeam(t *testing.T) {
+	s := apns2test.NewUnstartedServer()
+	s.TeamID = "OTHERTEAM1"
+	s.Start()
+	defer s.Close()
+	tok := mockToken(t)
+	s.AddAuthKey(tok.KeyID, &tok.AuthKey.PublicKey)
+	res, err := s.TokenClient(tok).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns2.ReasonInvalidProviderToken, res.Reason)
+}
+
+func TestTokenPushExpired(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	tok := mockToken(t)
+	s.AddAuthKey(tok.KeyID, &tok.AuthKey.PublicKey)
+	jwtToken := &jwt.Token{
+		Header: map[string]interface{}{"alg": "ES256", "kid": tok.KeyID},
+		Claims: jwt.MapClaims{"iss": tok.TeamID, "iat": time.Now().Add(-2 * time.Hour).Unix()},
+		Method: jwt.SigningMethodES256,
+	}
+	bearer, err := jwtToken.SignedString(tok.AuthKey)
+	assert.NoError(t, err)
+	tok.Bearer = bearer
+	tok.IssuedAt = time.Now().Unix()
+	res, err := s.TokenClient(tok).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, apns2.ReasonExpiredProviderToken, res.Reason)
+}
+
+func TestUnregistered(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.Unregister(mockDeviceToken, at)
+	res, err := s.Client(mockCertificate(t, "com.example.app")).Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+	assert.Equal(t, apns2.ReasonUnregistered, res.Reason)
+	assert.True(t, at.Equal(res.Timestamp.Time))
+}
+
+func TestInvalidNotifications(t *testing.T) {
+	s := apns2test.NewServer()
+	defer s.Close()
+	client := s.Client(mockCertificate(t, "com.example.app"))
+	tests := []struct {
+		mutate func(n *apns2.Notification)
+		status int
+		reason string
+	}{
+		{func(n *apns2.Notification) { n.DeviceToken = "nothex" }, http.StatusBadRequest, apns2.ReasonBadDeviceToken},
+		{func(n *apns2.Notification) { n.Priority = 7 }, http.StatusBadRequest, apns2.ReasonBadPriority},
+		{func(n *apns2.Notification) { n.PushType = "unknown" }, http.StatusBadRequest, apns2.ReasonInvalidPushType},
+		{func(n *apns2.Notification) { n.Payload = nil }, http.StatusBadRequest, apns2.ReasonPayloadEmpty},
+		{func(n *apns2.Notification) {
+			n.Payload = []byte(`{"aps":{"alert":"` + strings.Repeat("x", 4096) + `"}}`)
+		}, http.StatusRequestEntityTooLarge, apns2.ReasonPayloadTooLarge},
+		{func(n *apns2.Notification) {
+			n.PushType = apns2.PushTypeVOIP
+			n.Payload = []byte(`{"aps":{"alert":"` + strings.Repeat("x", 6000) + `"}}`)
+		}, http.StatusRequestEntityTooLarge, apns2.ReasonPayloadTooLarge},
+	}
+	for _, test := range tests {
+		n := mockNotification()
+		test.mutate(n)
+		res, err := client.Push(n)
+		assert.NoError(t, err)
+		assert.Equal(t, test.status, res.StatusCode)
+		assert.Equal(t, test.reason, res.Reason)
+	}
+}
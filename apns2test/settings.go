@@ -0,0 +1,240 @@
+package apns2test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	frameHeaderLen = 9
+
+	// defaultMaxConcurrentStreams is the limit advertised by the http2
+	// package when its MaxConcurrentStreams is 0.
+	defaultMaxConcurrentStreams = 250
+)
+
+// settingsConn is a server connection which can send a SETTINGS frame to the
+// client at any time, to change SETTINGS_MAX_CONCURRENT_STREAMS on a live
+// connection. The http2 package cannot change its settings once a connection
+// is served, so the connection advertises the limit itself:
+//
+//   - the limit in the server's initial SETTINGS frame is replaced with the
+//     current limit, so that the http2 server, which advertises no limit,
+//     never refuses a stream the client was allowed to open;
+//   - later SETTINGS frames are written between the server's frames;
+//   - the client's ACKs of those frames are dropped before the server reads
+//     them, as the server would close the connection on an unexpected ACK.
+type settingsConn struct {
+	*tls.Conn
+
+	wmu     sync.Mutex
+	limit   uint32
+	started bool
+	pending bool
+	wframe  frameState
+
+	rmu      sync.Mutex
+	preface  int
+	rframe   frameState
+	unacked  int
+	ackedAny bool
+	in       []byte
+	readErr  error
+	buf      [4096]byte
+}
+
+// frameState tracks the frame boundaries in one direction of a connection.
+type frameState struct {
+	header    []byte
+	remaining int
+	kept      bool
+}
+
+func newSettingsConn(c *tls.Conn, limit uint32) *settingsConn {
+	return &settingsConn{
+		Conn:    c,
+		limit:   limit,
+		preface: len(http2.ClientPreface),
+	}
+}
+
+// setMaxConcurrentStreams advertises a new limit to the client. It is sent
+// once the server finishes writing its current frame. A failed write is not
+// returned, as it is also seen by the server, which closes the connection.
+func (c *settingsConn) setMaxConcurrentStreams(n uint32) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.limit = n
+	if !c.started {
+		// The limit is advertised in the server's initial SETTINGS frame.
+		return
+	}
+	c.pending = true
+	if c.wframe.atBoundary() {
+		c.flushSettingsLocked()
+	}
+}
+
+// Write writes the server's frames, replacing the limit in its initial
+// SETTINGS frame and sending any pending SETTINGS frame at the next frame
+// boundary.
+func (c *settingsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	out := p
+	if !c.started {
+		c.started = true
+		out = c.patchInitialSettingsLocked(p)
+	}
+	c.wframe.advance(out, nil, nil)
+	n, err := c.Conn.Write(out)
+	if err != nil {
+		return n, err
+	}
+	if c.pending && c.wframe.atBoundary() {
+		if err := c.flushSettingsLocked(); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// patchInitialSettingsLocked returns p with the limit in the initial SETTINGS
+// frame at its start replaced. If p does not hold the whole frame, the limit
+// is sent in a separate SETTINGS frame instead. c.wmu must be held.
+func (c *settingsConn) patchInitialSettingsLocked(p []byte) []byte {
+	if len(p) >= frameHeaderLen && http2.FrameType(p[3]) == http2.FrameSettings {
+		length := frameLength(p)
+		if len(p) >= frameHeaderLen+length {
+			out := append([]byte(nil), p...)
+			payload := out[frameHeaderLen : frameHeaderLen+length]
+			for i := 0; i+6 <= len(payload); i += 6 {
+				if http2.SettingID(binary.BigEndian.Uint16(payload[i:])) == http2.SettingMaxConcurrentStreams {
+					binary.BigEndian.PutUint32(payload[i+2:], c.advertisedLocked())
+					return out
+				}
+			}
+		}
+	}
+	c.pending = true
+	return p
+}
+
+// flushSettingsLocked writes a SETTINGS frame with the current limit. c.wmu
+// must be held and the server must not be part way through a frame.
+func (c *settingsConn) flushSettingsLocked() error {
+	var buf bytes.Buffer
+	fr := http2.NewFramer(&buf, nil)
+	if err := fr.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: c.advertisedLocked()}); err != nil {
+		return err
+	}
+	c.pending = false
+	c.rmu.Lock()
+	c.unacked++
+	c.rmu.Unlock()
+	_, err := c.Conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *settingsConn) advertisedLocked() uint32 {
+	if c.limit == 0 {
+		return defaultMaxConcurrentStreams
+	}
+	return c.limit
+}
+
+// Read reads the client's frames, dropping the ACKs of the SETTINGS frames
+// written by setMaxConcurrentStreams.
+func (c *settingsConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.in) == 0 {
+		if err := c.readErr; err != nil {
+			c.readErr = nil
+			return 0, err
+		}
+		c.rmu.Unlock()
+		n, err := c.Conn.Read(c.buf[:])
+		c.rmu.Lock()
+		c.filterLocked(c.buf[:n])
+		c.readErr = err
+	}
+	n := copy(p, c.in)
+	c.in = c.in[n:]
+	return n, nil
+}
+
+// filterLocked appends the bytes read from the client to c.in, except for
+// the SETTINGS ACKs which the server does not expect. c.rmu must be held.
+func (c *settingsConn) filterLocked(b []byte) {
+	if c.preface > 0 {
+		n := minInt(c.preface, len(b))
+		c.in = append(c.in, b[:n]...)
+		c.preface -= n
+		b = b[n:]
+	}
+	c.in = c.rframe.advance(b, c.in, func(header []byte) bool {
+		if http2.FrameType(header[3]) != http2.FrameSettings || http2.Flags(header[4])&http2.FlagSettingsAck == 0 || frameLength(header) != 0 {
+			return true
+		}
+		// The first ACK is of the server's initial SETTINGS frame, which is
+		// always written before the others.
+		if !c.ackedAny || c.unacked == 0 {
+			c.ackedAny = true
+			return true
+		}
+		c.unacked--
+		return false
+	})
+}
+
+func (f *frameState) atBoundary() bool {
+	return f.remaining == 0 && len(f.header) == 0
+}
+
+// advance moves f past the frames in b. If keep is set, the frames for which
+// it returns true are appended to dst, and the result is returned. keep is
+// called once for each frame header.
+func (f *frameState) advance(b, dst []byte, keep func(header []byte) bool) []byte {
+	for len(b) > 0 {
+		if f.remaining > 0 {
+			n := minInt(f.remaining, len(b))
+			if keep != nil && f.kept {
+				dst = append(dst, b[:n]...)
+			}
+			f.remaining -= n
+			b = b[n:]
+			continue
+		}
+		n := minInt(frameHeaderLen-len(f.header), len(b))
+		f.header = append(f.header, b[:n]...)
+		b = b[n:]
+		if len(f.header) < frameHeaderLen {
+			continue
+		}
+		f.remaining = frameLength(f.header)
+		if keep != nil {
+			f.kept = keep(f.header)
+			if f.kept {
+				dst = append(dst, f.header...)
+			}
+		}
+		f.header = f.header[:0]
+	}
+	return dst
+}
+
+func frameLength(header []byte) int {
+	return int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+package apns2
+
+import (
+	"sync"
+)
+
+// DefaultBatchConcurrency is the number of notifications sent at the same
+// time by PushBatch and PushStream if BatchOptions.Concurrency is not set.
+var DefaultBatchConcurrency = 50
+
+// BatchOptions configures how PushBatch and PushStream send notifications.
+type BatchOptions struct {
+	// Concurrency is the maximum number of notifications sent at the same
+	// time. If zero, DefaultBatchConcurrency is used.
+	Concurrency int
+}
+
+// BatchResult is the outcome of sending a single notification as part of a
+// batch. Either Response or Err is set, as returned by PushWithContext.
+type BatchResult struct {
+	Notification *Notification
+	Response     *Response
+	Err          error
+}
+
+// BatchSummary holds the results of PushBatch along with counts of how the
+// notifications were handled by the APNs.
+type BatchSummary struct {
+	// Results holds a result for each notification, in the same order as the
+	// notifications passed to PushBatch.
+	Results []BatchResult
+
+	// Sent is the number of notifications accepted by the APNs.
+	Sent int
+
+	// Errors is the number of notifications which failed with an error.
+	Errors int
+
+	// StatusCodes counts the responses by HTTP status code.
+	StatusCodes map[int]int
+
+	// Reasons counts the rejected notifications by APNs Reason.
+	Reasons map[string]int
+}
+
+// Add records a result in the summary. It can be used to summarize the
+// results received from PushStream.
+func (s *BatchSummary) Add(r BatchResult) {
+	if s.StatusCodes == nil {
+		s.StatusCodes = map[int]int{}
+	}
+	if s.Reasons == nil {
+		s.Reasons = map[string]int{}
+	}
+	s.Results = append(s.Results, r)
+	if r.Err != nil || r.Response == nil {
+		s.Errors++
+		return
+	}
+	s.StatusCodes[r.Response.StatusCode]++
+	if r.Response.Sent() {
+		s.Sent++
+	} else {
+		s.Reasons[r.Response.Reason]++
+	}
+}
+
+// PushBatch sends notifications to the APNs gateway, sending up to
+// opts.Concurrency at the same time, and waits for all of them to complete.
+// opts can be nil to use the default options.
+//
+// Unlike Push, a failed notification does not stop the batch; the error is
+// recorded in its BatchResult. If the context is done, notifications which
+// have not yet been sent are given the context's error.
+func (c *Client) PushBatch(ctx Context, notifications []*Notification, opts *BatchOptions) *BatchSummary {
+	results := make([]BatchResult, len(notifications))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = c.pushResult(ctx, notifications[i])
+			}
+		}()
+	}
+	for i := range notifications {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	summary := &BatchSummary{Results: make([]BatchResult, 0, len(results))}
+	for _, r := range results {
+		summary.Add(r)
+	}
+	return summary
+}
+
+// PushStream sends the notifications received from the notifications channel
+// to the APNs gateway, sending up to opts.Concurrency at the same time. opts
+// can be nil to use the default options.
+//
+// A BatchResult is delivered on the returned channel for each notification,
+// in the order the pushes complete. The returned channel is closed once the
+// notifications channel is closed, or the context is done, and all in-flight
+// pushes have completed. The caller must receive every result.
+func (c *Client) PushStream(ctx Context, notifications <-chan *Notification, opts *BatchOptions) <-chan BatchResult {
+	results := make(chan BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case n, ok := <-notifications:
+					if !ok {
+						return
+					}
+					results <- c.pushResult(ctx, n)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func (c *Client) pushResult(ctx Context, n *Notification) BatchResult {
+	if err := ctx.Err(); err != nil {
+		return BatchResult{Notification: n, Err: err}
+	}
+	res, err := c.PushWithContext(ctx, n)
+	return BatchResult{Notification: n, Response: res, Err: err}
+}
+
+func (o *BatchOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return DefaultBatchConcurrency
+	}
+	return o.Concurrency
+}
@@ -0,0 +1,146 @@
+package apns2_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockBatchServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/bad"):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"reason":"BadDeviceToken"}`))
+		case strings.HasSuffix(r.URL.Path, "/gone"):
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered","timestamp":1458114061260}`))
+		}
+	}))
+}
+
+func mockBatchNotifications(tokens ...string) []*apns.Notification {
+	var notifications []*apns.Notification
+	for _, token := range tokens {
+		n := mockNotification()
+		n.DeviceToken = token
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+// Unit Tests
+
+func TestBatchSummaryAdd(t *testing.T) {
+	summary := &apns.BatchSummary{}
+	summary.Add(apns.BatchResult{Response: &apns.Response{StatusCode: 200}})
+	summary.Add(apns.BatchResult{Response: &apns.Response{StatusCode: 410, Reason: apns.ReasonUnregistered}})
+	summary.Add(apns.BatchResult{Err: context.Canceled})
+	assert.Len(t, summary.Results, 3)
+	assert.Equal(t, 1, summary.Sent)
+	assert.Equal(t, 1, summary.Errors)
+	assert.Equal(t, map[int]int{200: 1, 410: 1}, summary.StatusCodes)
+	assert.Equal(t, map[string]int{apns.ReasonUnregistered: 1}, summary.Reasons)
+}
+
+// Functional Tests
+
+func TestClientPushBatch(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	notifications := mockBatchNotifications("a1", "bad", "a2", "gone", "bad", "a3")
+	summary := mockClient(server.URL).PushBatch(context.Background(), notifications, &apns.BatchOptions{Concurrency: 2})
+	assert.Len(t, summary.Results, 6)
+	for i, r := range summary.Results {
+		assert.Equal(t, notifications[i], r.Notification)
+		assert.NoError(t, r.Err)
+	}
+	assert.Equal(t, apns.ReasonBadDeviceToken, summary.Results[1].Response.Reason)
+	assert.Equal(t, 3, summary.Sent)
+	assert.Equal(t, 0, summary.Errors)
+	assert.Equal(t, map[int]int{200: 3, 400: 2, 410: 1}, summary.StatusCodes)
+	assert.Equal(t, map[string]int{apns.ReasonBadDeviceToken: 2, apns.ReasonUnregistered: 1}, summary.Reasons)
+}
+
+func TestClientPushBatchConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+	}))
+	defer server.Close()
+
+	notifications := mockBatchNotifications("a", "b", "c", "d", "e", "f", "g", "h")
+	summary := mockClient(server.URL).PushBatch(context.Background(), notifications, &apns.BatchOptions{Concurrency: 3})
+	assert.Equal(t, 8, summary.Sent)
+	assert.True(t, atomic.LoadInt32(&maxInFlight) <= 3)
+}
+
+func TestClientPushBatchCanceledContext(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary := mockClient(server.URL).PushBatch(ctx, mockBatchNotifications("a", "b"), nil)
+	assert.Equal(t, 2, summary.Errors)
+	for _, r := range summary.Results {
+		assert.Equal(t, context.Canceled, r.Err)
+		assert.NotNil(t, r.Notification)
+	}
+}
+
+func TestClientPushStream(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	notifications := make(chan *apns.Notification)
+	go func() {
+		for _, n := range mockBatchNotifications("a1", "bad", "gone", "a2") {
+			notifications <- n
+		}
+		close(notifications)
+	}()
+
+	summary := &apns.BatchSummary{}
+	for r := range mockClient(server.URL).PushStream(context.Background(), notifications, &apns.BatchOptions{Concurrency: 2}) {
+		summary.Add(r)
+	}
+	assert.Len(t, summary.Results, 4)
+	assert.Equal(t, 2, summary.Sent)
+	assert.Equal(t, map[string]int{apns.ReasonBadDeviceToken: 1, apns.ReasonUnregistered: 1}, summary.Reasons)
+}
+
+func TestClientPushStreamStopsWhenContextDone(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := make(chan *apns.Notification)
+	results := mockClient(server.URL).PushStream(ctx, notifications, nil)
+	cancel()
+
+	select {
+	case _, ok := <-results:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("results channel was not closed")
+	}
+}
@@ -0,0 +1,139 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"time"
+)
+
+// Services which a topic in an Apple push certificate can be used for.
+const (
+	ServiceApp          = "app"
+	ServiceVOIP         = "voip"
+	ServiceComplication = "complication"
+)
+
+// ErrFailedToParseTopics is returned by Inspect when the topics extension of
+// a certificate cannot be parsed.
+var ErrFailedToParseTopics = errors.New("failed to parse certificate topics")
+
+// Object identifiers used by Apple in push certificates.
+var (
+	oidUID         = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+	oidDevelopment = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 1}
+	oidProduction  = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 2}
+	oidTopics      = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 6}
+)
+
+// Topic is a topic which a certificate can send notifications to, and the
+// services it can be used for.
+type Topic struct {
+	// Name is the topic, such as "com.example.app" or "com.example.app.voip".
+	Name string
+
+	// Services are the services the topic can be used for, such as
+	// ServiceApp, ServiceVOIP or ServiceComplication. It is empty for older
+	// certificates which only have a single topic.
+	Services []string
+}
+
+// Info describes an Apple push certificate.
+type Info struct {
+	// UID is the subject user ID of the certificate, which is the app's
+	// bundle ID.
+	UID string
+
+	// Topics are the topics the certificate can send notifications to. For
+	// older certificates without a topics extension this is the UID.
+	Topics []Topic
+
+	// Development and Production report whether the certificate can be used
+	// with the development and production APNs environments. Universal
+	// certificates can be used with both.
+	Development bool
+	Production  bool
+
+	// NotBefore and NotAfter are the bounds of the certificate's validity.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// HasTopic reports whether the certificate can send notifications to topic.
+func (i Info) HasTopic(topic string) bool {
+	for _, t := range i.Topics {
+		if t.Name == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect returns the topics, environments and validity of an Apple push
+// certificate, such as one loaded with FromP12File or FromPemFile.
+func Inspect(cert tls.Certificate) (Info, error) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return Info{}, ErrNoCertificate
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return Info{}, err
+		}
+	}
+
+	info := Info{
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+	}
+	for _, name := range leaf.Subject.Names {
+		if uid, ok := name.Value.(string); ok && name.Type.Equal(oidUID) {
+			info.UID = uid
+		}
+	}
+	for _, ext := range leaf.Extensions {
+		switch {
+		case ext.Id.Equal(oidDevelopment):
+			info.Development = true
+		case ext.Id.Equal(oidProduction):
+			info.Production = true
+		case ext.Id.Equal(oidTopics):
+			topics, err := parseTopics(ext.Value)
+			if err != nil {
+				return Info{}, err
+			}
+			info.Topics = topics
+		}
+	}
+	if info.Topics == nil && info.UID != "" {
+		info.Topics = []Topic{{Name: info.UID}}
+	}
+	return info, nil
+}
+
+// parseTopics parses the topics extension, which is a sequence of topics,
+// each followed by a sequence of the services it can be used for.
+func parseTopics(der []byte) ([]Topic, error) {
+	var values []asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &values); err != nil || len(rest) > 0 {
+		return nil, ErrFailedToParseTopics
+	}
+	var topics []Topic
+	for _, value := range values {
+		switch {
+		case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagUTF8String:
+			topics = append(topics, Topic{Name: string(value.Bytes)})
+		case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagSequence && len(topics) > 0:
+			var services []string
+			if rest, err := asn1.Unmarshal(value.FullBytes, &services); err != nil || len(rest) > 0 {
+				return nil, ErrFailedToParseTopics
+			}
+			topics[len(topics)-1].Services = services
+		default:
+			return nil, ErrFailedToParseTopics
+		}
+	}
+	return topics, nil
+}
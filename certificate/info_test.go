@@ -0,0 +1,114 @@
+package certificate_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockUTF8(s string) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(s)}
+}
+
+func mockServices(services ...string) asn1.RawValue {
+	var values []asn1.RawValue
+	for _, s := range services {
+		values = append(values, mockUTF8(s))
+	}
+	der, _ := asn1.Marshal(values)
+	return asn1.RawValue{FullBytes: der}
+}
+
+func mockAPNsCertificate(t *testing.T, extensions ...pkix.Extension) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "Apple Push Services: com.example.app",
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: "com.example.app"},
+			},
+		},
+		NotBefore:       time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExtraExtensions: extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var (
+	mockDevelopmentExtension = pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 1}, Value: []byte{5, 0}}
+	mockProductionExtension  = pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 2}, Value: []byte{5, 0}}
+)
+
+func mockTopicsExtension(values ...asn1.RawValue) pkix.Extension {
+	der, _ := asn1.Marshal(values)
+	return pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 6}, Value: der}
+}
+
+// Unit Tests
+
+func TestInspectUniversalCertificate(t *testing.T) {
+	cert := mockAPNsCertificate(t, mockDevelopmentExtension, mockProductionExtension, mockTopicsExtension(
+		mockUTF8("com.example.app"), mockServices(certificate.ServiceApp),
+		mockUTF8("com.example.app.voip"), mockServices(certificate.ServiceVOIP),
+		mockUTF8("com.example.app.complication"), mockServices(certificate.ServiceComplication),
+	))
+	info, err := certificate.Inspect(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.app", info.UID)
+	assert.Equal(t, []certificate.Topic{
+		{Name: "com.example.app", Services: []string{"app"}},
+		{Name: "com.example.app.voip", Services: []string{"voip"}},
+		{Name: "com.example.app.complication", Services: []string{"complication"}},
+	}, info.Topics)
+	assert.True(t, info.Development)
+	assert.True(t, info.Production)
+	assert.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), info.NotBefore)
+	assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), info.NotAfter)
+	assert.True(t, info.HasTopic("com.example.app.voip"))
+	assert.False(t, info.HasTopic("com.example.other"))
+}
+
+func TestInspectDevelopmentCertificate(t *testing.T) {
+	info, err := certificate.Inspect(mockAPNsCertificate(t, mockDevelopmentExtension))
+	assert.NoError(t, err)
+	assert.True(t, info.Development)
+	assert.False(t, info.Production)
+	assert.Equal(t, []certificate.Topic{{Name: "com.example.app"}}, info.Topics)
+}
+
+func TestInspectInvalidTopics(t *testing.T) {
+	ext := mockTopicsExtension(mockUTF8("com.example.app"))
+	ext.Value = []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	_, err := certificate.Inspect(mockAPNsCertificate(t, ext))
+	assert.Equal(t, certificate.ErrFailedToParseTopics, err)
+}
+
+func TestInspectNoCertificate(t *testing.T) {
+	_, err := certificate.Inspect(tls.Certificate{})
+	assert.Equal(t, certificate.ErrNoCertificate, err)
+}
+
+func TestInspectFixture(t *testing.T) {
+	cert, _ := certificate.FromPemFile("_fixtures/certificate-valid.pem", "")
+	info, err := certificate.Inspect(cert)
+	assert.NoError(t, err)
+	assert.Empty(t, info.Topics)
+	assert.Equal(t, cert.Leaf.NotAfter, info.NotAfter)
+}
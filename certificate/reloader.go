@@ -0,0 +1,178 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCheckInterval is the default interval at which a Reloader checks for
+// a new certificate.
+var DefaultCheckInterval = time.Minute
+
+// Reloader holds a certificate which is reloaded from a file or a callback
+// when it changes, so long-running clients can pick up renewed certificates
+// without restarting. Use GetClientCertificate in a tls.Config so that new
+// connections use the current certificate. Existing connections keep using
+// the certificate they were established with.
+//
+// The certificate is checked for changes at most once every CheckInterval,
+// when a new connection is made, or when Reload is called.
+type Reloader struct {
+	// CheckInterval is the minimum interval between checks for a new
+	// certificate.
+	CheckInterval time.Duration
+
+	// OnReload, if set, is called with the new certificate after it has been
+	// reloaded. OnReload and OnError are called without the Reloader's lock
+	// held, so they may call its methods.
+	OnReload func(cert tls.Certificate)
+
+	// OnError, if set, is called when the certificate fails to reload. The
+	// previous certificate continues to be used.
+	OnError func(err error)
+
+	load    func() (tls.Certificate, error)
+	changed func() bool
+
+	mu      sync.Mutex
+	cert    tls.Certificate
+	checked time.Time
+}
+
+// NewReloader returns a Reloader which loads its certificate by calling load.
+// Each check calls load, and the certificate is replaced if it differs from
+// the current one. It returns an error if the initial load fails.
+func NewReloader(load func() (tls.Certificate, error)) (*Reloader, error) {
+	r := &Reloader{
+		CheckInterval: DefaultCheckInterval,
+		load:          load,
+	}
+	cert, err := load()
+	if err != nil {
+		return nil, err
+	}
+	r.cert = cert
+	r.checked = time.Now()
+	return r, nil
+}
+
+// NewFileReloader returns a Reloader which loads its certificate from a
+// PKCS#12 file, if filename ends in .p12 or .pfx, or otherwise a PEM file. The
+// file is reloaded when its modification time or size changes. It returns an
+// error if the initial load fails.
+//
+// Use "" as the password argument if the certificate is not password
+// protected.
+func NewFileReloader(filename string, password string) (*Reloader, error) {
+	var modTime time.Time
+	var size int64
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	load := func() (tls.Certificate, error) {
+		m, s := stat()
+		lower := strings.ToLower(filename)
+		var cert tls.Certificate
+		var err error
+		if strings.HasSuffix(lower, ".p12") || strings.HasSuffix(lower, ".pfx") {
+			cert, err = FromP12File(filename, password)
+		} else {
+			cert, err = FromPemFile(filename, password)
+		}
+		if err == nil {
+			modTime, size = m, s
+		}
+		return cert, err
+	}
+	r, err := NewReloader(load)
+	if err != nil {
+		return nil, err
+	}
+	r.changed = func() bool {
+		m, s := stat()
+		return !m.Equal(modTime) || s != size
+	}
+	return r, nil
+}
+
+// Certificate returns the current certificate.
+func (r *Reloader) Certificate() tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert
+}
+
+// GetClientCertificate returns the current certificate, after checking for a
+// new one if CheckInterval has passed since the last check. It can be used as
+// the GetClientCertificate function of a tls.Config.
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	var reloaded bool
+	var err error
+	if time.Since(r.checked) >= r.CheckInterval {
+		if r.changed == nil || r.changed() {
+			reloaded, err = r.reloadLocked()
+		}
+		r.checked = time.Now()
+	}
+	cert := r.cert
+	r.mu.Unlock()
+	r.notify(cert, reloaded, err)
+	return &cert, nil
+}
+
+// Reload reloads the certificate immediately. If it fails, the error is
+// returned and the previous certificate continues to be used.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	r.checked = time.Now()
+	reloaded, err := r.reloadLocked()
+	cert := r.cert
+	r.mu.Unlock()
+	r.notify(cert, reloaded, err)
+	return err
+}
+
+// reloadLocked loads the certificate and reports whether it changed.
+func (r *Reloader) reloadLocked() (bool, error) {
+	cert, err := r.load()
+	if err != nil {
+		return false, err
+	}
+	if sameCertificate(cert, r.cert) {
+		return false, nil
+	}
+	r.cert = cert
+	return true, nil
+}
+
+// notify calls OnReload or OnError with the result of a reload. It is called
+// without r.mu held, so the callbacks can use the Reloader.
+func (r *Reloader) notify(cert tls.Certificate, reloaded bool, err error) {
+	if err != nil && r.OnError != nil {
+		r.OnError(err)
+	}
+	if reloaded && r.OnReload != nil {
+		r.OnReload(cert)
+	}
+}
+
+func sameCertificate(a, b tls.Certificate) bool {
+	if len(a.Certificate) != len(b.Certificate) {
+		return false
+	}
+	for i := range a.Certificate {
+		if !bytes.Equal(a.Certificate[i], b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
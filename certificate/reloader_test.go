@@ -0,0 +1,142 @@
+package certificate_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockWritePemFile(t *testing.T, filename string, cert tls.Certificate, modTime time.Time) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})...)
+	assert.NoError(t, os.WriteFile(filename, data, 0600))
+	assert.NoError(t, os.Chtimes(filename, modTime, modTime))
+}
+
+// Unit Tests
+
+func TestFileReloader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cert.pem")
+	first, second := mockAPNsCertificate(t), mockAPNsCertificate(t)
+	mockWritePemFile(t, filename, first, time.Now().Add(-time.Hour))
+
+	r, err := certificate.NewFileReloader(filename, "")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Certificate, r.Certificate().Certificate)
+
+	var reloaded []tls.Certificate
+	r.OnReload = func(cert tls.Certificate) { reloaded = append(reloaded, cert) }
+	r.CheckInterval = 0
+
+	cert, err := r.GetClientCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Certificate, cert.Certificate)
+	assert.Len(t, reloaded, 0)
+
+	mockWritePemFile(t, filename, second, time.Now())
+	cert, err = r.GetClientCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, second.Certificate, cert.Certificate)
+	if assert.Len(t, reloaded, 1) {
+		assert.Equal(t, second.Certificate, reloaded[0].Certificate)
+	}
+}
+
+func TestFileReloaderKeepsCertificateOnError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cert.pem")
+	cert := mockAPNsCertificate(t)
+	mockWritePemFile(t, filename, cert, time.Now().Add(-time.Hour))
+
+	r, err := certificate.NewFileReloader(filename, "")
+	assert.NoError(t, err)
+	var errs []error
+	r.OnError = func(err error) { errs = append(errs, err) }
+	r.CheckInterval = 0
+
+	assert.NoError(t, os.WriteFile(filename, []byte("renewing"), 0600))
+	current, err := r.GetClientCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Certificate, current.Certificate)
+	assert.Equal(t, []error{certificate.ErrNoCertificate}, errs)
+	assert.Equal(t, certificate.ErrNoCertificate, r.Reload())
+}
+
+func TestFileReloaderP12(t *testing.T) {
+	r, err := certificate.NewFileReloader("_fixtures/certificate-valid.p12", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, r.Certificate().Leaf)
+}
+
+func TestFileReloaderMissingFile(t *testing.T) {
+	r, err := certificate.NewFileReloader(filepath.Join(t.TempDir(), "missing.pem"), "")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+}
+
+func TestReloaderCallback(t *testing.T) {
+	certs := []tls.Certificate{mockAPNsCertificate(t), mockAPNsCertificate(t)}
+	calls := 0
+	r, err := certificate.NewReloader(func() (tls.Certificate, error) {
+		calls++
+		return certs[(calls-1)%2], nil
+	})
+	assert.NoError(t, err)
+
+	cert, _ := r.GetClientCertificate(nil)
+	assert.Equal(t, certs[0].Certificate, cert.Certificate)
+	assert.Equal(t, 1, calls)
+
+	var reloads int
+	r.OnReload = func(tls.Certificate) { reloads++ }
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, certs[1].Certificate, r.Certificate().Certificate)
+	assert.Equal(t, 1, reloads)
+}
+
+func TestReloaderCallbacksCanUseReloader(t *testing.T) {
+	certs := []tls.Certificate{mockAPNsCertificate(t), mockAPNsCertificate(t)}
+	calls := 0
+	r, err := certificate.NewReloader(func() (tls.Certificate, error) {
+		calls++
+		if calls == 3 {
+			return tls.Certificate{}, errors.New("secret not found")
+		}
+		return certs[(calls-1)%2], nil
+	})
+	assert.NoError(t, err)
+
+	var reloaded, current tls.Certificate
+	r.OnReload = func(cert tls.Certificate) {
+		reloaded = cert
+		current = r.Certificate()
+		assert.EqualError(t, r.Reload(), "secret not found")
+	}
+	var errs []error
+	r.OnError = func(err error) {
+		errs = append(errs, err)
+		r.Certificate()
+	}
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, certs[1].Certificate, reloaded.Certificate)
+	assert.Equal(t, certs[1].Certificate, current.Certificate)
+	assert.Len(t, errs, 1)
+}
+
+func TestReloaderCallbackError(t *testing.T) {
+	_, err := certificate.NewReloader(func() (tls.Certificate, error) {
+		return tls.Certificate{}, errors.New("secret not found")
+	})
+	assert.EqualError(t, err, "secret not found")
+}
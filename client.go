@@ -11,9 +11,13 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/sideshow/apns2/certificate"
 	"github.com/sideshow/apns2/token"
 	"golang.org/x/net/http2"
 )
@@ -46,6 +50,11 @@ var (
 	// TLSDialTimeout is the maximum amount of time a dial will wait for a connect
 	// to complete.
 	TLSDialTimeout = 20 * time.Second
+
+	// MaxResponseBodySize is the maximum number of bytes read from the body of
+	// a response. APNs responses are small, so this guards against buffering
+	// large bodies returned by a misbehaving intermediary.
+	MaxResponseBodySize int64 = 4 << 10
 )
 
 // DialTLS is the default dial function for creating TLS connections for
@@ -64,6 +73,25 @@ type Client struct {
 	Certificate tls.Certificate
 	Token       *token.Token
 	HTTPClient  *http.Client
+
+	// TokenProvider, if set, provides the bearer tokens used for token-based
+	// authentication instead of Token. If the APNs rejects a bearer as
+	// expired or invalid, the push is retried once if the provider has a new
+	// bearer.
+	TokenProvider token.Provider
+
+	// RetryPolicy decides whether failed pushes are retried. If nil, pushes
+	// are not retried.
+	RetryPolicy RetryPolicy
+
+	// ValidateNotifications enables validation of every notification with
+	// Notification.Validate before it is sent. Invalid notifications are not
+	// sent, and Push returns a *ValidationError.
+	ValidateNotifications bool
+
+	mu       sync.Mutex
+	inFlight int
+	drained  []chan struct{}
 }
 
 // A Context carries a deadline, a cancellation signal, and other values across
@@ -73,10 +101,6 @@ type Context interface {
 	context.Context
 }
 
-type connectionCloser interface {
-	CloseIdleConnections()
-}
-
 // NewClient returns a new Client with an underlying http.Client configured with
 // the correct APNs HTTP/2 transport settings. It does not connect to the APNs
 // until the first Notification is sent via the Push method.
@@ -100,6 +124,7 @@ func NewClient(certificate tls.Certificate) *Client {
 		DialTLS:         DialTLS,
 		ReadIdleTimeout: ReadIdleTimeout,
 	}
+	newPooledTransport(transport, 1)
 	return &Client{
 		HTTPClient: &http.Client{
 			Transport: transport,
@@ -110,6 +135,20 @@ func NewClient(certificate tls.Certificate) *Client {
 	}
 }
 
+// NewReloadableClient returns a new Client like NewClient, except that its
+// certificate is provided by the Reloader. New connections to the APNs use the
+// current certificate, so a renewed certificate is picked up without creating
+// a new Client. The Certificate field holds the certificate loaded when the
+// Client was created.
+func NewReloadableClient(reloader *certificate.Reloader) *Client {
+	c := NewClient(reloader.Certificate())
+	tlsConfig := c.HTTPClient.Transport.(*http2.Transport).TLSClientConfig
+	tlsConfig.Certificates = nil
+	tlsConfig.NameToCertificate = nil
+	tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	return c
+}
+
 // NewTokenClient returns a new Client with an underlying http.Client configured
 // with the correct APNs HTTP/2 transport settings. It does not connect to the APNs
 // until the first Notification is sent via the Push method.
@@ -123,6 +162,7 @@ func NewTokenClient(token *token.Token) *Client {
 		DialTLS:         DialTLS,
 		ReadIdleTimeout: ReadIdleTimeout,
 	}
+	newPooledTransport(transport, 1)
 	return &Client{
 		Token: token,
 		HTTPClient: &http.Client{
@@ -133,6 +173,37 @@ func NewTokenClient(token *token.Token) *Client {
 	}
 }
 
+// NewTokenProviderClient returns a new Client like NewTokenClient, except that
+// bearer tokens are provided by the token.Provider, such as a
+// token.Refresher.
+func NewTokenProviderClient(provider token.Provider) *Client {
+	c := NewTokenClient(nil)
+	c.TokenProvider = provider
+	return c
+}
+
+// NewPooledClient returns a new Client like NewClient, except that the
+// underlying transport keeps size HTTP/2 connections open to the APNs and
+// spreads notifications across them using a ConnPool.
+//
+// A single connection is limited to the number of concurrent streams
+// advertised by the APNs. Use a pooled client if you need to send more
+// notifications concurrently than a single connection allows.
+func NewPooledClient(certificate tls.Certificate, size int) *Client {
+	c := NewClient(certificate)
+	newPooledTransport(c.HTTPClient.Transport.(*http2.Transport), size)
+	return c
+}
+
+// NewPooledTokenClient returns a new Client like NewTokenClient, except that
+// the underlying transport keeps size HTTP/2 connections open to the APNs and
+// spreads notifications across them using a ConnPool.
+func NewPooledTokenClient(token *token.Token, size int) *Client {
+	c := NewTokenClient(token)
+	newPooledTransport(c.HTTPClient.Transport.(*http2.Transport), size)
+	return c
+}
+
 // Development sets the Client to use the APNs development push endpoint.
 func (c *Client) Development() *Client {
 	c.Host = HostDevelopment
@@ -165,23 +236,98 @@ func (c *Client) Push(n *Notification) (*Response, error) {
 // attempt to reconnect transparently before sending the notification. It will
 // return a Response indicating whether the notification was accepted or
 // rejected by the APNs gateway, or an error if something goes wrong.
+//
+// If the Client uses token authentication and a provider token cannot be
+// generated, the notification is not sent and a *token.GenerateError is
+// returned. If the Client has a TokenProvider and the APNs rejects its
+// bearer, the push is retried once with a new bearer.
+//
+// If the Client has a RetryPolicy, failed attempts are retried with the same
+// ApnsID until the policy gives up, the Notification expires or the context
+// is done.
 func (c *Client) PushWithContext(ctx Context, n *Notification) (*Response, error) {
+	c.begin()
+	defer c.end()
+
 	payload, err := json.Marshal(n)
 	if err != nil {
 		return nil, err
 	}
+	if c.ValidateNotifications {
+		if err := n.validate(payload); err != nil {
+			return nil, err
+		}
+	}
 
+	apnsID := n.ApnsID
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		request, err := c.newRequest(ctx, n, payload, apnsID)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.do(ctx, request)
+		if err == nil && !refreshed && c.tokenRejected(request, res) {
+			// Retry immediately with the new bearer, without counting
+			// the rejection as an attempt.
+			refreshed = true
+			if apnsID == "" {
+				apnsID = res.ApnsID
+			}
+			attempt--
+			continue
+		}
+		if c.RetryPolicy == nil || (err == nil && res.Sent()) {
+			return res, err
+		}
+		delay, retry := c.RetryPolicy.Retry(attempt, res, err)
+		if !retry {
+			return res, err
+		}
+		if n.Expiration.After(time.Unix(0, 0)) && time.Now().Add(delay).After(n.Expiration) {
+			return res, err
+		}
+		if apnsID == "" && res != nil {
+			apnsID = res.ApnsID
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) newRequest(ctx Context, n *Notification, payload []byte, apnsID string) (*http.Request, error) {
 	url := c.Host + "/3/device/" + n.DeviceToken
 	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
 
-	if c.Token != nil {
-		c.setTokenHeader(request)
+	if c.Token != nil || c.TokenProvider != nil {
+		if err := c.setTokenHeader(request); err != nil {
+			return nil, err
+		}
 	}
 
 	setHeaders(request, n)
+	if apnsID != "" {
+		request.Header.Set("apns-id", apnsID)
+	}
+	return request, nil
+}
+
+func (c *Client) do(ctx Context, request *http.Request) (*Response, error) {
+	if p := c.connPool(); p != nil {
+		addr := poolAddr(request.URL)
+		if err := p.acquire(ctx, addr); err != nil {
+			return nil, err
+		}
+		defer p.release(addr)
+	}
 
 	response, err := c.HTTPClient.Do(request)
 	if err != nil {
@@ -194,9 +340,19 @@ func (c *Client) PushWithContext(ctx Context, n *Notification) (*Response, error
 	r.ApnsID = response.Header.Get("apns-id")
 	r.ApnsUniqueID = response.Header.Get("apns-unique-id")
 
-	decoder := json.NewDecoder(response.Body)
-	if err := decoder.Decode(r); err != nil && err != io.EOF {
-		return &Response{}, err
+	body, err := io.ReadAll(io.LimitReader(response.Body, MaxResponseBodySize))
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(r)
+	}
+	if err != nil && err != io.EOF {
+		return r, &ResponseError{
+			StatusCode:   response.StatusCode,
+			ApnsID:       r.ApnsID,
+			ApnsUniqueID: r.ApnsUniqueID,
+			Header:       response.Header,
+			Body:         body,
+			Err:          err,
+		}
 	}
 	return r, nil
 }
@@ -205,12 +361,109 @@ func (c *Client) PushWithContext(ctx Context, n *Notification) (*Response, error
 // connected from previous requests but are now sitting idle. It will not
 // interrupt any connections currently in use.
 func (c *Client) CloseIdleConnections() {
-	c.HTTPClient.Transport.(connectionCloser).CloseIdleConnections()
+	if p := c.connPool(); p != nil {
+		p.CloseIdleConnections()
+	}
+	c.HTTPClient.CloseIdleConnections()
+}
+
+// Close waits for pushes in flight to finish, and then closes the Client's
+// connections to the APNs. The Client can still be used after it is closed,
+// in which case it reconnects.
+func (c *Client) Close() {
+	c.mu.Lock()
+	if c.inFlight > 0 {
+		drained := make(chan struct{})
+		c.drained = append(c.drained, drained)
+		c.mu.Unlock()
+		<-drained
+	} else {
+		c.mu.Unlock()
+	}
+	c.CloseIdleConnections()
 }
 
-func (c *Client) setTokenHeader(r *http.Request) {
-	bearer := c.Token.GenerateIfExpired()
+func (c *Client) begin() {
+	c.mu.Lock()
+	c.inFlight++
+	c.mu.Unlock()
+}
+
+func (c *Client) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if c.inFlight == 0 {
+		for _, drained := range c.drained {
+			close(drained)
+		}
+		c.drained = nil
+	}
+}
+
+// StreamStats returns the current stream capacity and queue depth of the
+// Client's connections to its Host. Pushes block once every stream advertised by the APNs is in use,
+// so this can be used to size worker pools. Clients created with NewClient or
+// NewTokenClient use a ConnPool with a single connection. It returns a zero
+// StreamStats if the HTTPClient's transport has been replaced and does not
+// use a ConnPool. A transport which wraps an http2.Transport can keep its
+// ConnPool visible by implementing Unwrap() http.RoundTripper.
+func (c *Client) StreamStats() StreamStats {
+	p := c.connPool()
+	if p == nil {
+		return StreamStats{}
+	}
+	u, err := url.Parse(c.Host)
+	if err != nil {
+		return StreamStats{}
+	}
+	return p.hostStats(poolAddr(u))
+}
+
+// connPool returns the ConnPool of the Client's transport. Transports which
+// wrap another, such as a recording transport, are unwrapped if they have an
+// Unwrap() http.RoundTripper method.
+func (c *Client) connPool() *ConnPool {
+	rt := c.HTTPClient.Transport
+	for {
+		switch t := rt.(type) {
+		case *http2.Transport:
+			p, _ := t.ConnPool.(*ConnPool)
+			return p
+		case interface{ Unwrap() http.RoundTripper }:
+			rt = t.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) setTokenHeader(r *http.Request) error {
+	var bearer string
+	var err error
+	if c.TokenProvider != nil {
+		bearer, err = c.TokenProvider.Bearer()
+	} else {
+		bearer, err = c.Token.GenerateIfExpiredWithError()
+	}
+	if err != nil {
+		return err
+	}
 	r.Header.Set("authorization", "bearer "+bearer)
+	return nil
+}
+
+// tokenRejected reports whether res rejected the bearer sent with r, and the
+// TokenProvider has a new bearer to retry with.
+func (c *Client) tokenRejected(r *http.Request, res *Response) bool {
+	if c.TokenProvider == nil {
+		return false
+	}
+	if res.Reason != ReasonExpiredProviderToken && res.Reason != ReasonInvalidProviderToken {
+		return false
+	}
+	bearer := strings.TrimPrefix(r.Header.Get("authorization"), "bearer ")
+	return c.TokenProvider.Rejected(bearer, res.Reason)
 }
 
 func setHeaders(r *http.Request, n *Notification) {
@@ -2,19 +2,130 @@ package apns2
 
 import (
 	"container/list"
+	"context"
 	"crypto/sha1"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/sideshow/apns2/clock"
+	"github.com/sideshow/apns2/token"
 )
 
+// ClientKey identifies the credentials and host of a Client in a
+// ClientManager. Certificate clients are keyed by a hash of their certificate
+// chain and their Host, and token clients by their Team ID, Key ID and Host.
+// ClientManager.Get matches a certificate client whatever its Host.
+type ClientKey struct {
+	// CertificateHash is the SHA-1 hash of the certificate chain, for
+	// certificate clients.
+	CertificateHash [sha1.Size]byte
+
+	// TeamID and KeyID identify token clients.
+	TeamID string
+	KeyID  string
+
+	// Host is the APNs host of the Client. Universal certificates and tokens
+	// can be used with both the development and production hosts, which
+	// need separate clients.
+	Host string
+}
+
+// CertificateKey returns the ClientKey of a Client using the certificate to
+// connect to host.
+func CertificateKey(certificate tls.Certificate, host string) ClientKey {
+	return ClientKey{CertificateHash: cacheKey(certificate), Host: host}
+}
+
+// TokenKey returns the ClientKey of a Client using the token to connect to
+// host.
+func TokenKey(t *token.Token, host string) ClientKey {
+	return ClientKey{TeamID: t.TeamID, KeyID: t.KeyID, Host: host}
+}
+
+// KeyOf returns the ClientKey of the Client. If the Client has a Token, it is
+// keyed by the Token and its Host. If it has a TokenProvider which implements
+// token.KeyedProvider, it is keyed by the provider's Key and its Host. Otherwise it is keyed by its Certificate and Host.
+func KeyOf(client *Client) ClientKey {
+	if client.Token != nil {
+		return TokenKey(client.Token, client.Host)
+	}
+	if p, ok := client.TokenProvider.(token.KeyedProvider); ok {
+		teamID, keyID := p.Key()
+		return ClientKey{TeamID: teamID, KeyID: keyID, Host: client.Host}
+	}
+	return CertificateKey(client.Certificate, client.Host)
+}
+
+// ErrNilClient is returned by ClientManager.GetWithContext when the Factory
+// returns nil.
+var ErrNilClient = errors.New("apns2: client factory returned nil")
+
+// ErrFactoryPanic is wrapped by the error returned by
+// ClientManager.GetWithContext when the Factory panics.
+var ErrFactoryPanic = errors.New("apns2: client factory panicked")
+
 type managerItem struct {
-	key      [sha1.Size]byte
+	key      ClientKey
 	client   *Client
+	created  time.Time
 	lastUsed time.Time
+	hits     int64
+	misses   int64
 }
 
-// ClientManager is a way to manage multiple connections to the APNs.
+// ClientStats describes a Client in a ClientManager.
+type ClientStats struct {
+	Key ClientKey
+
+	// Created is when the Client was added to the manager, and Age is the
+	// time since then.
+	Created time.Time
+	Age     time.Duration
+
+	// LastUsed is when the Client was last added or returned by Get.
+	LastUsed time.Time
+
+	// Hits is the number of times the Client was returned by Get without
+	// calling the Factory, and Misses is the number of calls to Get which
+	// waited for the Factory to create it.
+	Hits   int64
+	Misses int64
+}
+
+// ClientManagerStats is a snapshot of the clients in a ClientManager and its
+// usage since it was created.
+type ClientManagerStats struct {
+	// Clients are the clients in the manager, most recently used first.
+	Clients []ClientStats
+
+	// Hits and Misses are the number of calls to Get which did and did not
+	// find a Client in the manager.
+	Hits   int64
+	Misses int64
+
+	// Evictions is the number of clients evicted from the manager.
+	Evictions int64
+
+	// FactoryFailures is the number of times the Factory returned nil or
+	// panicked.
+	FactoryFailures int64
+}
+
+// factoryCall is a call to a Factory which is in progress. Concurrent calls to
+// Get for the same key wait for it to finish.
+type factoryCall struct {
+	done   chan struct{}
+	client *Client
+	err    error
+	misses int64
+}
+
+// ClientManager is a way to manage multiple connections to the APNs. Clients
+// are keyed by their credentials, so both certificate and token clients can
+// be managed, see ClientKey.
 type ClientManager struct {
 	// MaxSize is the maximum number of clients allowed in the manager. When
 	// this limit is reached, the least recently used client is evicted. Set
@@ -31,10 +142,38 @@ type ClientManager struct {
 	// manager.
 	Factory func(certificate tls.Certificate) *Client
 
-	cache map[[sha1.Size]byte]*list.Element
-	ll    *list.List
-	mu    sync.Mutex
-	once  sync.Once
+	// TokenFactory is the function which constructs token clients for a host
+	// if not found in the manager.
+	TokenFactory func(token *token.Token, host string) *Client
+
+	// SweepInterval is the interval at which clients which have remained
+	// unused for MaxAge are evicted in the background. Sweeping starts on the
+	// next call to Add or Get once it is set. Set zero to only evict them
+	// upon retrieval.
+	SweepInterval time.Duration
+
+	// OnEvict, if set, is called when a client is evicted from the manager,
+	// because MaxSize was reached, it exceeded MaxAge, it was replaced by
+	// Add, or it was removed by Remove. Evicted clients are closed once their
+	// pushes in flight finish.
+	OnEvict func(key ClientKey, client *Client)
+
+	// Clock is used to tell when clients are added and used, and their age.
+	// If nil, the system clock is used. Background sweeping waits on the
+	// system clock.
+	Clock clock.Clock
+
+	hits            int64
+	misses          int64
+	evictions       int64
+	factoryFailures int64
+
+	cache    map[ClientKey]*list.Element
+	calls    map[ClientKey]*factoryCall
+	ll       *list.List
+	mu       sync.Mutex
+	once     sync.Once
+	sweeping chan struct{}
 }
 
 // NewClientManager returns a new ClientManager for prolonged, concurrent usage
@@ -42,15 +181,18 @@ type ClientManager struct {
 // your use case. When a client is not found in the manager, Get will return
 // the result of calling Factory, which can be a Client or nil.
 //
-// Having multiple clients per certificate in the manager is not allowed.
+// Having multiple clients per certificate and host, or per token and host, in
+// the manager is not allowed.
 //
-// By default, MaxSize is 64, MaxAge is 10 minutes, and Factory always returns
-// a Client with default options.
+// By default, MaxSize is 64, MaxAge is 10 minutes, Factory and TokenFactory
+// always return a Client with default options, and Clock is clock.Real.
 func NewClientManager() *ClientManager {
 	manager := &ClientManager{
-		MaxSize: 64,
-		MaxAge:  10 * time.Minute,
-		Factory: NewClient,
+		MaxSize:      64,
+		MaxAge:       10 * time.Minute,
+		Factory:      NewClient,
+		TokenFactory: newTokenClientForHost,
+		Clock:        clock.Real,
 	}
 
 	manager.initInternals()
@@ -58,64 +200,363 @@ func NewClientManager() *ClientManager {
 	return manager
 }
 
-// Add adds a Client to the manager. You can use this to individually configure
-// Clients in the manager.
+// Add adds a Client to the manager, keyed by KeyOf. You can use this to
+// individually configure Clients in the manager.
 func (m *ClientManager) Add(client *Client) {
+	m.add(KeyOf(client), client)
+}
+
+func (m *ClientManager) add(key ClientKey, client *Client) {
 	m.initInternals()
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.startSweepLocked()
+	evicted := m.addLocked(key, client, m.now())
+	m.mu.Unlock()
+	m.evict(evicted)
+}
 
-	key := cacheKey(client.Certificate)
-	now := time.Now()
+// addLocked adds the client to the manager and returns the clients which it
+// evicts. m.mu must be held.
+func (m *ClientManager) addLocked(key ClientKey, client *Client, now time.Time) []*managerItem {
 	if ele, hit := m.cache[key]; hit {
 		item := ele.Value.(*managerItem)
+		var evicted []*managerItem
+		if item.client != client {
+			evicted = append(evicted, &managerItem{key: key, client: item.client})
+			item.created = now
+			item.hits = 0
+			item.misses = 0
+		}
 		item.client = client
 		item.lastUsed = now
 		m.ll.MoveToFront(ele)
-		return
+		m.evictions += int64(len(evicted))
+		return evicted
 	}
-	ele := m.ll.PushFront(&managerItem{key, client, now})
+	ele := m.ll.PushFront(&managerItem{key: key, client: client, created: now, lastUsed: now})
 	m.cache[key] = ele
 	if m.MaxSize != 0 && m.ll.Len() > m.MaxSize {
-		m.mu.Unlock()
-		m.removeOldest()
-		m.mu.Lock()
+		oldest := m.ll.Back()
+		m.removeElementLocked(oldest)
+		m.evictions++
+		return []*managerItem{oldest.Value.(*managerItem)}
 	}
+	return nil
 }
 
 // Get gets a Client from the manager. If a Client is not found in the manager
 // or if a Client has remained in the manager longer than MaxAge, Get will call
 // the ClientManager's Factory function, store the result in the manager if
 // non-nil, and return it.
+//
+// Get returns a Client for the certificate whatever its Host, such as a
+// production Client added with Add, and the Client returned by the Factory is
+// keyed by its own Host. Use GetForHost to get a Client for a specific host.
+//
+// The Factory is called without holding the manager's lock, so a slow Factory
+// does not block Get for other certificates. Concurrent calls for the same
+// certificate share a single call to the Factory.
 func (m *ClientManager) Get(certificate tls.Certificate) *Client {
+	c, _ := m.GetWithContext(context.Background(), certificate)
+	return c
+}
+
+// GetWithContext gets a Client from the manager like Get, but returns the
+// context's error if it is done before the Factory returns, and
+// ErrNilClient if the Factory returns nil. If the context is done, the Factory
+// call continues and its result is stored in the manager.
+func (m *ClientManager) GetWithContext(ctx Context, certificate tls.Certificate) (*Client, error) {
+	return m.get(ctx, CertificateKey(certificate, ""), func() *Client {
+		return m.Factory(certificate)
+	})
+}
+
+// GetForHost gets a Client for host from the manager like Get. If a Client is
+// not found, the Client returned by the Factory is set to use host. Use it
+// with a universal certificate to keep separate clients for the development
+// and production hosts.
+func (m *ClientManager) GetForHost(certificate tls.Certificate, host string) *Client {
+	c, _ := m.GetForHostWithContext(context.Background(), certificate, host)
+	return c
+}
+
+// GetForHostWithContext gets a Client for host from the manager like
+// GetForHost, but returns an error like GetWithContext.
+func (m *ClientManager) GetForHostWithContext(ctx Context, certificate tls.Certificate, host string) (*Client, error) {
+	return m.get(ctx, CertificateKey(certificate, host), func() *Client {
+		c := m.Factory(certificate)
+		if c != nil {
+			c.Host = host
+		}
+		return c
+	})
+}
+
+// GetToken gets a token Client for host from the manager. If a Client is not
+// found in the manager or if a Client has remained in the manager longer than
+// MaxAge, GetToken will call the ClientManager's TokenFactory function, store
+// the result in the manager if non-nil, and return it.
+func (m *ClientManager) GetToken(t *token.Token, host string) *Client {
+	c, _ := m.GetTokenWithContext(context.Background(), t, host)
+	return c
+}
+
+// GetTokenWithContext gets a token Client for host from the manager like
+// GetToken, but returns an error like GetWithContext.
+func (m *ClientManager) GetTokenWithContext(ctx Context, t *token.Token, host string) (*Client, error) {
+	return m.get(ctx, TokenKey(t, host), func() *Client {
+		return m.TokenFactory(t, host)
+	})
+}
+
+// get returns the Client with the key, calling the factory if it is not found
+// or has expired. A certificate key without a Host matches a Client for the
+// certificate with any Host.
+func (m *ClientManager) get(ctx Context, key ClientKey, factory func() *Client) (*Client, error) {
 	m.initInternals()
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.startSweepLocked()
 
-	key := cacheKey(certificate)
-	now := time.Now()
-	if ele, hit := m.cache[key]; hit {
+	now := m.now()
+	if ele, hit := m.lookupLocked(key); hit {
 		item := ele.Value.(*managerItem)
-		if m.MaxAge != 0 && item.lastUsed.Before(now.Add(-m.MaxAge)) {
-			c := m.Factory(certificate)
-			if c == nil {
-				return nil
-			}
-			item.client = c
+		if m.MaxAge == 0 || !item.lastUsed.Before(now.Add(-m.MaxAge)) {
+			m.hits++
+			item.hits++
+			item.lastUsed = now
+			m.ll.MoveToFront(ele)
+			c := item.client
+			m.mu.Unlock()
+			return c, nil
 		}
-		item.lastUsed = now
-		m.ll.MoveToFront(ele)
-		return item.client
 	}
 
-	c := m.Factory(certificate)
-	if c == nil {
-		return nil
+	m.misses++
+	call, ok := m.calls[key]
+	if !ok {
+		call = &factoryCall{done: make(chan struct{})}
+		m.calls[key] = call
+		go m.callFactory(key, call, factory)
+	}
+	call.misses++
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	if call.client == nil {
+		return nil, ErrNilClient
+	}
+	return call.client, nil
+}
+
+// lookupLocked returns the element with the key. If the key is a certificate
+// key without a Host, it returns the most recently used element for the
+// certificate with any Host. m.mu must be held.
+func (m *ClientManager) lookupLocked(key ClientKey) (*list.Element, bool) {
+	if ele, hit := m.cache[key]; hit || key.Host != "" || key.TeamID != "" || key.KeyID != "" {
+		return ele, hit
+	}
+	for e := m.ll.Front(); e != nil; e = e.Next() {
+		k := e.Value.(*managerItem).key
+		if k.CertificateHash == key.CertificateHash && k.TeamID == "" && k.KeyID == "" {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// callFactory calls the factory and stores the client it returns in the
+// manager, replacing any expired client. If the key has no Host, the client
+// is stored with its own Host. If the factory panics, the panic is returned
+// to the callers waiting for it as an error.
+func (m *ClientManager) callFactory(key ClientKey, call *factoryCall, factory func() *Client) {
+	defer close(call.done)
+	c, err := callRecovered(factory)
+	m.mu.Lock()
+	delete(m.calls, key)
+	var evicted []*managerItem
+	if c != nil {
+		if key.Host == "" {
+			key.Host = c.Host
+		}
+		evicted = m.addLocked(key, c, m.now())
+		if ele, ok := m.cache[key]; ok {
+			ele.Value.(*managerItem).misses += call.misses
+		}
+	} else {
+		m.factoryFailures++
 	}
+	call.client = c
+	call.err = err
 	m.mu.Unlock()
-	m.Add(c)
+	m.evict(evicted)
+}
+
+// callRecovered calls the factory and returns a panic as an error wrapping
+// ErrFactoryPanic.
+func callRecovered(factory func() *Client) (c *Client, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("%w: %v", ErrFactoryPanic, r)
+		}
+	}()
+	return factory(), nil
+}
+
+// Close removes every Client from the manager and closes them, waiting for
+// pushes in flight to finish. The manager can still be used after it is
+// closed.
+func (m *ClientManager) Close() {
+	m.initInternals()
 	m.mu.Lock()
-	return c
+	var clients []*Client
+	for e := m.ll.Front(); e != nil; e = e.Next() {
+		clients = append(clients, e.Value.(*managerItem).client)
+	}
+	m.cache = map[ClientKey]*list.Element{}
+	m.ll.Init()
+	if m.sweeping != nil {
+		close(m.sweeping)
+		m.sweeping = nil
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			c.Close()
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Remove removes the Client with the key from the manager, such as when its
+// credentials have been revoked, and closes it once its pushes in flight
+// finish. It returns false if there is no Client with the key.
+func (m *ClientManager) Remove(key ClientKey) bool {
+	m.initInternals()
+	m.mu.Lock()
+	ele, hit := m.cache[key]
+	if hit {
+		m.removeElementLocked(ele)
+		m.evictions++
+	}
+	m.mu.Unlock()
+	if hit {
+		m.evict([]*managerItem{ele.Value.(*managerItem)})
+	}
+	return hit
+}
+
+// Keys returns the keys of the clients in the manager, most recently used
+// first.
+func (m *ClientManager) Keys() []ClientKey {
+	m.initInternals()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]ClientKey, 0, m.ll.Len())
+	for e := m.ll.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*managerItem).key)
+	}
+	return keys
+}
+
+// Range calls f for each client in the manager, most recently used first,
+// until f returns false. It ranges over a snapshot of the manager, so f can
+// call other methods of the manager.
+func (m *ClientManager) Range(f func(key ClientKey, client *Client) bool) {
+	m.initInternals()
+	m.mu.Lock()
+	items := make([]managerItem, 0, m.ll.Len())
+	for e := m.ll.Front(); e != nil; e = e.Next() {
+		items = append(items, *e.Value.(*managerItem))
+	}
+	m.mu.Unlock()
+	for _, item := range items {
+		if !f(item.key, item.client) {
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of the clients in the manager and its usage.
+func (m *ClientManager) Stats() ClientManagerStats {
+	m.initInternals()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	stats := ClientManagerStats{
+		Clients:         make([]ClientStats, 0, m.ll.Len()),
+		Hits:            m.hits,
+		Misses:          m.misses,
+		Evictions:       m.evictions,
+		FactoryFailures: m.factoryFailures,
+	}
+	for e := m.ll.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*managerItem)
+		stats.Clients = append(stats.Clients, ClientStats{
+			Key:      item.key,
+			Created:  item.created,
+			Age:      now.Sub(item.created),
+			LastUsed: item.lastUsed,
+			Hits:     item.hits,
+			Misses:   item.misses,
+		})
+	}
+	return stats
+}
+
+// Sweep evicts every client which has remained unused in the manager for
+// MaxAge or longer. It is called every SweepInterval if set.
+func (m *ClientManager) Sweep() {
+	m.initInternals()
+	m.mu.Lock()
+	var evicted []*managerItem
+	if m.MaxAge != 0 {
+		deadline := m.now().Add(-m.MaxAge)
+		for e := m.ll.Back(); e != nil; {
+			item, prev := e.Value.(*managerItem), e.Prev()
+			if !item.lastUsed.Before(deadline) {
+				break
+			}
+			m.removeElementLocked(e)
+			evicted = append(evicted, item)
+			e = prev
+		}
+	}
+	m.evictions += int64(len(evicted))
+	m.mu.Unlock()
+	m.evict(evicted)
+}
+
+// startSweepLocked starts sweeping in the background if SweepInterval is set
+// and it is not already running. m.mu must be held.
+func (m *ClientManager) startSweepLocked() {
+	if m.SweepInterval <= 0 || m.sweeping != nil {
+		return
+	}
+	stop := make(chan struct{})
+	m.sweeping = stop
+	go func(interval time.Duration) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.Sweep()
+			}
+		}
+	}(m.SweepInterval)
 }
 
 // Len returns the current size of the ClientManager.
@@ -130,27 +571,35 @@ func (m *ClientManager) Len() int {
 
 func (m *ClientManager) initInternals() {
 	m.once.Do(func() {
-		m.cache = map[[sha1.Size]byte]*list.Element{}
+		m.cache = map[ClientKey]*list.Element{}
+		m.calls = map[ClientKey]*factoryCall{}
 		m.ll = list.New()
 	})
 }
 
-func (m *ClientManager) removeOldest() {
-	m.mu.Lock()
-	ele := m.ll.Back()
-	m.mu.Unlock()
-	if ele != nil {
-		m.removeElement(ele)
+func (m *ClientManager) now() time.Time {
+	if m.Clock == nil {
+		return clock.Real.Now()
 	}
+	return m.Clock.Now()
 }
 
-func (m *ClientManager) removeElement(e *list.Element) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *ClientManager) removeElementLocked(e *list.Element) {
 	m.ll.Remove(e)
 	delete(m.cache, e.Value.(*managerItem).key)
 }
 
+// evict calls OnEvict for each evicted client, and closes it in the
+// background once its pushes in flight have finished.
+func (m *ClientManager) evict(items []*managerItem) {
+	for _, item := range items {
+		if m.OnEvict != nil {
+			m.OnEvict(item.key, item.client)
+		}
+		go item.client.Close()
+	}
+}
+
 func cacheKey(certificate tls.Certificate) [sha1.Size]byte {
 	var data []byte
 
@@ -160,3 +609,9 @@ func cacheKey(certificate tls.Certificate) [sha1.Size]byte {
 
 	return sha1.Sum(data)
 }
+
+func newTokenClientForHost(t *token.Token, host string) *Client {
+	c := NewTokenClient(t)
+	c.Host = host
+	return c
+}
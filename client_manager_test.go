@@ -2,14 +2,19 @@ package apns2_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
 	"github.com/sideshow/apns2/certificate"
+	"github.com/sideshow/apns2/clock"
+	"github.com/sideshow/apns2/token"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -101,6 +106,31 @@ func TestClientManagerGetMaxAgeExpiration(t *testing.T) {
 	assert.Equal(t, 1, manager.Len())
 }
 
+func TestClientManagerMaxAgeWithClock(t *testing.T) {
+	fake := apns2test.NewFakeClock(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+	manager := apns2.NewClientManager()
+	manager.Clock = fake
+	c1 := manager.Get(mockCert())
+	fake.Advance(manager.MaxAge - time.Nanosecond)
+	assert.Same(t, c1, manager.Get(mockCert()))
+	fake.Advance(manager.MaxAge + time.Nanosecond)
+	c2 := manager.Get(mockCert())
+	assert.NotSame(t, c1, c2)
+
+	stats := manager.Stats()
+	assert.Equal(t, fake.Now(), stats.Clients[0].Created)
+	fake.Advance(time.Minute)
+	assert.Equal(t, time.Minute, manager.Stats().Clients[0].Age)
+
+	fake.Advance(manager.MaxAge + time.Nanosecond)
+	manager.Sweep()
+	assert.Equal(t, 0, manager.Len())
+}
+
+func TestClientManagerDefaultClock(t *testing.T) {
+	assert.Equal(t, clock.Real, apns2.NewClientManager().Clock)
+}
+
 func TestClientManagerGetMaxAgeExpirationWithNilFactory(t *testing.T) {
 	manager := apns2.NewClientManager()
 	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
@@ -141,9 +171,402 @@ func TestClientManagerAdd(t *testing.T) {
 	manager.Get(mockCert())
 }
 
+func TestClientManagerAddForHostThenGet(t *testing.T) {
+	manager := apns2.NewClientManager()
+	client := apns2.NewClient(mockCert()).Production()
+	manager.Add(client)
+	assert.Same(t, client, manager.Get(mockCert()))
+	assert.Same(t, client, manager.GetForHost(mockCert(), apns2.HostProduction))
+	assert.Equal(t, 1, manager.Len())
+
+	development := manager.GetForHost(mockCert(), apns2.HostDevelopment)
+	assert.NotSame(t, client, development)
+	assert.Same(t, development, manager.Get(mockCert()))
+	assert.Equal(t, 2, manager.Len())
+}
+
 func TestClientManagerAddTwice(t *testing.T) {
 	manager := apns2.NewClientManager()
 	manager.Add(apns2.NewClient(mockCert()))
 	manager.Add(apns2.NewClient(mockCert()))
 	assert.Equal(t, 1, manager.Len())
 }
+
+func TestClientManagerGetToken(t *testing.T) {
+	manager := apns2.NewClientManager()
+	tok := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	c1 := manager.GetToken(tok, apns2.HostProduction)
+	c2 := manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	assert.NotNil(t, c1)
+	assert.Same(t, c1, c2)
+	assert.Same(t, tok, c1.Token)
+	assert.Equal(t, apns2.HostProduction, c1.Host)
+	assert.Equal(t, 1, manager.Len())
+}
+
+func TestClientManagerGetTokenKeys(t *testing.T) {
+	manager := apns2.NewClientManager()
+	tok := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	production := manager.GetToken(tok, apns2.HostProduction)
+	development := manager.GetToken(tok, apns2.HostDevelopment)
+	otherKey := manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG"}, apns2.HostProduction)
+	otherTeam := manager.GetToken(&token.Token{TeamID: "GHI123JKLM", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	certClient := manager.Get(mockCert())
+	assert.NotSame(t, production, development)
+	assert.NotSame(t, production, otherKey)
+	assert.NotSame(t, production, otherTeam)
+	assert.NotSame(t, production, certClient)
+	assert.Equal(t, 5, manager.Len())
+}
+
+func TestClientManagerGetTokenMaxAgeExpiration(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxAge = time.Nanosecond
+	tok := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	c1 := manager.GetToken(tok, apns2.HostProduction)
+	time.Sleep(time.Microsecond)
+	c2 := manager.GetToken(tok, apns2.HostProduction)
+	assert.NotSame(t, c1, c2)
+	assert.Equal(t, 1, manager.Len())
+}
+
+func TestClientManagerAddToken(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.TokenFactory = func(*token.Token, string) *apns2.Client {
+		t.Fatal("factory should not have been called")
+		return nil
+	}
+	client := apns2.NewTokenClient(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}).Production()
+	manager.Add(client)
+	assert.Same(t, client, manager.GetToken(client.Token, apns2.HostProduction))
+	assert.Equal(t, apns2.TokenKey(client.Token, apns2.HostProduction), apns2.KeyOf(client))
+}
+
+func TestClientManagerTokenMaxSizeExceeded(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxSize = 1
+	manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	manager.Get(mockCert())
+	assert.Equal(t, 1, manager.Len())
+}
+
+func TestClientManagerKeyOfCertificateClient(t *testing.T) {
+	client := apns2.NewClient(mockCert()).Production()
+	assert.Equal(t, apns2.CertificateKey(mockCert(), apns2.HostProduction), apns2.KeyOf(client))
+	assert.NotEqual(t, apns2.KeyOf(client), apns2.KeyOf(apns2.NewClient(mockCert()).Development()))
+}
+
+func TestClientManagerKeyOfProviderClient(t *testing.T) {
+	refresher := token.NewRefresher(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"})
+	client := apns2.NewTokenProviderClient(refresher).Production()
+	assert.Equal(t, apns2.ClientKey{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG", Host: apns2.HostProduction}, apns2.KeyOf(client))
+
+	rotator := token.NewRotator(&token.Token{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG"}, nil)
+	other := apns2.NewTokenProviderClient(rotator).Production()
+	assert.Equal(t, apns2.ClientKey{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG", Host: apns2.HostProduction}, apns2.KeyOf(other))
+
+	manager := apns2.NewClientManager()
+	manager.Add(client)
+	manager.Add(other)
+	assert.Equal(t, 2, manager.Len())
+}
+
+func TestClientManagerKeyOfProviderClientIsStable(t *testing.T) {
+	rotator := token.NewRotator(&token.Token{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG"}, &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"})
+	client := apns2.NewTokenProviderClient(rotator).Production()
+	manager := apns2.NewClientManager()
+	manager.Add(client)
+	key := apns2.KeyOf(client)
+
+	rotator.Promote()
+	assert.Equal(t, key, apns2.KeyOf(client))
+	manager.Add(client)
+	assert.Equal(t, 1, manager.Len())
+
+	rotator.SetKeys(&token.Token{TeamID: "DEF123GHIJ", KeyID: "NEW123DEFG"}, nil)
+	assert.True(t, manager.Remove(apns2.KeyOf(client)))
+	assert.Equal(t, 0, manager.Len())
+}
+
+func TestClientManagerGetForHost(t *testing.T) {
+	manager := apns2.NewClientManager()
+	production := manager.GetForHost(mockCert(), apns2.HostProduction)
+	development := manager.GetForHost(mockCert(), apns2.HostDevelopment)
+	assert.NotSame(t, production, development)
+	assert.Equal(t, apns2.HostProduction, production.Host)
+	assert.Equal(t, apns2.HostDevelopment, development.Host)
+	assert.Same(t, production, manager.GetForHost(mockCert(), apns2.HostProduction))
+	assert.Equal(t, 2, manager.Len())
+
+	called := false
+	manager.Factory = func(tls.Certificate) *apns2.Client {
+		called = true
+		return nil
+	}
+	client := apns2.NewClient(mockCert()).Production()
+	manager.Add(client)
+	assert.Same(t, client, manager.GetForHost(mockCert(), apns2.HostProduction))
+	assert.False(t, called, "factory should not have been called")
+}
+
+func TestClientManagerOnEvictMaxSize(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxSize = 1
+	var evicted []*apns2.Client
+	manager.OnEvict = func(key apns2.ClientKey, client *apns2.Client) {
+		assert.Equal(t, apns2.KeyOf(client), key)
+		evicted = append(evicted, client)
+	}
+	c1 := manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	manager.Get(mockCert())
+	assert.Equal(t, []*apns2.Client{c1}, evicted)
+}
+
+func TestClientManagerOnEvictMaxAge(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxAge = time.Nanosecond
+	var evicted []*apns2.Client
+	manager.OnEvict = func(key apns2.ClientKey, client *apns2.Client) {
+		evicted = append(evicted, client)
+	}
+	c1 := manager.Get(mockCert())
+	time.Sleep(time.Microsecond)
+	manager.Get(mockCert())
+	assert.Equal(t, []*apns2.Client{c1}, evicted)
+}
+
+func TestClientManagerOnEvictReplaced(t *testing.T) {
+	manager := apns2.NewClientManager()
+	var evicted []*apns2.Client
+	manager.OnEvict = func(key apns2.ClientKey, client *apns2.Client) {
+		evicted = append(evicted, client)
+	}
+	c1 := apns2.NewClient(mockCert())
+	manager.Add(c1)
+	manager.Add(c1)
+	assert.Len(t, evicted, 0)
+	manager.Add(apns2.NewClient(mockCert()))
+	assert.Equal(t, []*apns2.Client{c1}, evicted)
+}
+
+func TestClientManagerClose(t *testing.T) {
+	transport := &mockTransport{}
+	client := apns2.NewClient(mockCert())
+	client.HTTPClient.Transport = transport
+
+	manager := apns2.NewClientManager()
+	manager.Add(client)
+	manager.Close()
+	assert.True(t, transport.closed)
+	assert.Equal(t, 0, manager.Len())
+	assert.NotNil(t, manager.Get(mockCert()))
+}
+
+func TestClientManagerGetWithContextNilClient(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		return nil
+	}
+	c, err := manager.GetWithContext(context.Background(), mockCert())
+	assert.Nil(t, c)
+	assert.Equal(t, apns2.ErrNilClient, err)
+}
+
+func TestClientManagerGetWithContextFactoryPanic(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		panic("certificate not found")
+	}
+	c, err := manager.GetWithContext(context.Background(), mockCert())
+	assert.Nil(t, c)
+	assert.True(t, errors.Is(err, apns2.ErrFactoryPanic))
+	assert.Contains(t, err.Error(), "certificate not found")
+	assert.Nil(t, manager.Get(mockCert()))
+	assert.Equal(t, int64(2), manager.Stats().FactoryFailures)
+
+	manager.Factory = apns2.NewClient
+	assert.NotNil(t, manager.Get(mockCert()))
+}
+
+func TestClientManagerSlowFactoryDoesNotBlockOtherKeys(t *testing.T) {
+	unblock := make(chan struct{})
+	manager := apns2.NewClientManager()
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		if len(certificate.Certificate) == 0 {
+			<-unblock
+		}
+		return apns2.NewClient(certificate)
+	}
+	slow := make(chan *apns2.Client)
+	go func() {
+		slow <- manager.Get(mockCert())
+	}()
+
+	cert, _ := certificate.FromP12File("certificate/_fixtures/certificate-valid.p12", "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := manager.GetWithContext(ctx, cert)
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+
+	close(unblock)
+	assert.NotNil(t, <-slow)
+	assert.Equal(t, 2, manager.Len())
+}
+
+func TestClientManagerConcurrentGetCallsFactoryOnce(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	unblock := make(chan struct{})
+	manager := apns2.NewClientManager()
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-unblock
+		return apns2.NewClient(certificate)
+	}
+
+	var wg sync.WaitGroup
+	clients := make([]*apns2.Client, 10)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = manager.Get(mockCert())
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+	for _, c := range clients {
+		assert.Same(t, clients[0], c)
+	}
+}
+
+func TestClientManagerGetWithContextTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	manager := apns2.NewClientManager()
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		<-unblock
+		return apns2.NewClient(certificate)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c, err := manager.GetWithContext(ctx, mockCert())
+	assert.Nil(t, c)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The factory call continues, and its client is stored in the manager.
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return manager.Len() == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestClientManagerKeysAndRange(t *testing.T) {
+	manager := apns2.NewClientManager()
+	tok := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	tokenClient := manager.GetToken(tok, apns2.HostProduction)
+	certClient := manager.Get(mockCert())
+	assert.Equal(t, []apns2.ClientKey{apns2.CertificateKey(mockCert(), apns2.DefaultHost), apns2.TokenKey(tok, apns2.HostProduction)}, manager.Keys())
+
+	var clients []*apns2.Client
+	manager.Range(func(key apns2.ClientKey, client *apns2.Client) bool {
+		assert.Equal(t, apns2.KeyOf(client), key)
+		clients = append(clients, client)
+		return true
+	})
+	assert.Equal(t, []*apns2.Client{certClient, tokenClient}, clients)
+
+	clients = nil
+	manager.Range(func(key apns2.ClientKey, client *apns2.Client) bool {
+		clients = append(clients, client)
+		return false
+	})
+	assert.Len(t, clients, 1)
+}
+
+func TestClientManagerRemove(t *testing.T) {
+	manager := apns2.NewClientManager()
+	var evicted []apns2.ClientKey
+	manager.OnEvict = func(key apns2.ClientKey, client *apns2.Client) {
+		evicted = append(evicted, key)
+	}
+	manager.Get(mockCert())
+	key := apns2.CertificateKey(mockCert(), apns2.DefaultHost)
+	assert.True(t, manager.Remove(key))
+	assert.False(t, manager.Remove(key))
+	assert.Equal(t, 0, manager.Len())
+	assert.Equal(t, []apns2.ClientKey{key}, evicted)
+	assert.Equal(t, int64(1), manager.Stats().Evictions)
+}
+
+func TestClientManagerStats(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxSize = 1
+	manager.Get(mockCert())
+	manager.Get(mockCert())
+	manager.Get(mockCert())
+	if stats := manager.Stats(); assert.Len(t, stats.Clients, 1) {
+		assert.Equal(t, int64(2), stats.Clients[0].Hits)
+		assert.Equal(t, int64(1), stats.Clients[0].Misses)
+	}
+	manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	manager.Factory = func(certificate tls.Certificate) *apns2.Client {
+		return nil
+	}
+	manager.Get(mockCert())
+
+	stats := manager.Stats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(3), stats.Misses)
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, int64(1), stats.FactoryFailures)
+	if assert.Len(t, stats.Clients, 1) {
+		client := stats.Clients[0]
+		assert.Equal(t, apns2.TokenKey(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction), client.Key)
+		assert.Equal(t, int64(0), client.Hits)
+		assert.Equal(t, int64(1), client.Misses)
+		assert.False(t, client.LastUsed.Before(client.Created))
+		assert.True(t, client.Age >= 0)
+	}
+}
+
+func TestClientManagerSweep(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxAge = 20 * time.Millisecond
+	manager.Get(mockCert())
+	manager.Sweep()
+	assert.Equal(t, 1, manager.Len())
+	time.Sleep(25 * time.Millisecond)
+	manager.GetToken(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)
+	manager.Sweep()
+	assert.Equal(t, []apns2.ClientKey{apns2.TokenKey(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}, apns2.HostProduction)}, manager.Keys())
+}
+
+func TestClientManagerSweepInterval(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxAge = time.Millisecond
+	manager.SweepInterval = time.Millisecond
+	defer manager.Close()
+	manager.Get(mockCert())
+	assert.Eventually(t, func() bool {
+		return manager.Len() == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), manager.Stats().Evictions)
+}
+
+func TestClientManagerSweepIntervalSetAfterGet(t *testing.T) {
+	manager := apns2.NewClientManager()
+	manager.MaxAge = time.Millisecond
+	defer manager.Close()
+	manager.Get(mockCert())
+	manager.SweepInterval = time.Millisecond
+	manager.Get(mockCert())
+	assert.Eventually(t, func() bool {
+		return manager.Len() == 0
+	}, time.Second, time.Millisecond)
+}
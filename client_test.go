@@ -44,7 +44,7 @@ func mockCert() tls.Certificate {
 }
 
 func mockClient(url string) *apns.Client {
-	return &apns.Client{Host: url, HTTPClient: http.DefaultClient}
+	return &apns.Client{Host: url, HTTPClient: &http.Client{}}
 }
 
 type mockTransport struct {
@@ -125,6 +125,19 @@ func TestClientNameToCertificate(t *testing.T) {
 	assert.Len(t, name2, 0)
 }
 
+func TestNewReloadableClient(t *testing.T) {
+	reloader, err := certificate.NewFileReloader("certificate/_fixtures/certificate-valid.pem", "")
+	assert.NoError(t, err)
+	client := apns.NewReloadableClient(reloader)
+	assert.Equal(t, reloader.Certificate(), client.Certificate)
+
+	tlsConfig := client.HTTPClient.Transport.(*http2.Transport).TLSClientConfig
+	assert.Len(t, tlsConfig.Certificates, 0)
+	cert, err := tlsConfig.GetClientCertificate(&tls.CertificateRequestInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, reloader.Certificate().Certificate, cert.Certificate)
+}
+
 func TestDialTLSTimeout(t *testing.T) {
 	apns.TLSDialTimeout = 10 * time.Millisecond
 	crt, _ := certificate.FromP12File("certificate/_fixtures/certificate-valid.p12", "")
@@ -378,6 +391,98 @@ func TestAuthorizationHeader(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAuthorizationHeaderTokenError(t *testing.T) {
+	n := mockNotification()
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.Token = &token.Token{KeyID: "ABC123DEFG"}
+	res, err := client.Push(n)
+	assert.Nil(t, res)
+	var generateErr *token.GenerateError
+	assert.True(t, errors.As(err, &generateErr))
+	assert.Equal(t, "ABC123DEFG", generateErr.KeyID)
+	assert.True(t, errors.Is(err, token.ErrAuthKeyNil))
+	assert.Equal(t, 0, requests)
+}
+
+type mockTokenProvider struct {
+	bearer   string
+	next     string
+	rejected []string
+}
+
+func (p *mockTokenProvider) Bearer() (string, error) {
+	return p.bearer, nil
+}
+
+func (p *mockTokenProvider) Rejected(bearer, reason string) bool {
+	p.rejected = append(p.rejected, reason)
+	if p.next == "" {
+		return false
+	}
+	p.bearer, p.next = p.next, ""
+	return true
+}
+
+func mockTokenRejectingServer(requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Header.Get("authorization"))
+		w.Header().Set("apns-id", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01")
+		if r.Header.Get("authorization") != "bearer fresh" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"reason":"ExpiredProviderToken"}`))
+		}
+	}))
+}
+
+func TestTokenProviderRetriesRejectedBearer(t *testing.T) {
+	var requests []string
+	server := mockTokenRejectingServer(&requests)
+	defer server.Close()
+
+	provider := &mockTokenProvider{bearer: "stale", next: "fresh"}
+	client := apns.NewTokenProviderClient(provider)
+	client.Host = server.URL
+	client.HTTPClient = &http.Client{}
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, []string{"bearer stale", "bearer fresh"}, requests)
+	assert.Equal(t, []string{apns.ReasonExpiredProviderToken}, provider.rejected)
+}
+
+func TestTokenProviderRetriesOnce(t *testing.T) {
+	var requests []string
+	server := mockTokenRejectingServer(&requests)
+	defer server.Close()
+
+	provider := &mockTokenProvider{bearer: "stale", next: "also-stale"}
+	client := mockClient(server.URL)
+	client.TokenProvider = provider
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns.ReasonExpiredProviderToken, res.Reason)
+	assert.Equal(t, []string{"bearer stale", "bearer also-stale"}, requests)
+}
+
+func TestTokenProviderNotRefreshed(t *testing.T) {
+	var requests []string
+	server := mockTokenRejectingServer(&requests)
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.TokenProvider = &mockTokenProvider{bearer: "stale"}
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Len(t, requests, 1)
+}
+
 func TestPayload(t *testing.T) {
 	n := mockNotification()
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -462,7 +567,75 @@ func TestMalformedJSONResponse(t *testing.T) {
 	defer server.Close()
 	res, err := mockClient(server.URL).Push(n)
 	assert.Error(t, err)
-	assert.Equal(t, false, res.Sent())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestMalformedJSONResponseError(t *testing.T) {
+	n := mockNotification()
+	var apnsID = "02ABC856-EF8D-4E49-8F15-7B8A61D978D6"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("apns-id", apnsID)
+		w.Header().Set("apns-unique-id", "A6739D99-D92A-424B-A91E-BF012365BD4E")
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>Bad Gateway</html>"))
+	}))
+	defer server.Close()
+	res, err := mockClient(server.URL).Push(n)
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	assert.Equal(t, apnsID, res.ApnsID)
+	var resErr *apns.ResponseError
+	assert.True(t, errors.As(err, &resErr))
+	assert.Equal(t, http.StatusBadGateway, resErr.StatusCode)
+	assert.Equal(t, apnsID, resErr.ApnsID)
+	assert.Equal(t, "A6739D99-D92A-424B-A91E-BF012365BD4E", resErr.ApnsUniqueID)
+	assert.Equal(t, "text/html", resErr.Header.Get("Content-Type"))
+	assert.Equal(t, []byte("<html>Bad Gateway</html>"), resErr.Body)
+	assert.Contains(t, err.Error(), "status 502")
+	assert.True(t, apns.IsRetryable(err))
+}
+
+func TestResponseBodySizeLimit(t *testing.T) {
+	n := mockNotification()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"reason":"` + strings.Repeat("x", 10000) + `"}`))
+	}))
+	defer server.Close()
+	_, err := mockClient(server.URL).Push(n)
+	var resErr *apns.ResponseError
+	assert.True(t, errors.As(err, &resErr))
+	assert.Equal(t, http.StatusBadRequest, resErr.StatusCode)
+	assert.Len(t, resErr.Body, int(apns.MaxResponseBodySize))
+	assert.False(t, apns.IsRetryable(err))
+}
+
+func TestEmptyErrorResponse(t *testing.T) {
+	n := mockNotification()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	res, err := mockClient(server.URL).Push(n)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.True(t, apns.IsRetryable(res.Err()))
+}
+
+func TestValidateNotificationsBeforeSending(t *testing.T) {
+	n := mockNotification()
+	n.DeviceToken = "not-a-token"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	client := mockClient(server.URL)
+	client.ValidateNotifications = true
+	res, err := client.Push(n)
+	assert.Nil(t, res)
+	assert.True(t, errors.Is(err, apns.ErrBadDeviceToken))
+	assert.Equal(t, 0, requests)
 }
 
 func TestCloseIdleConnections(t *testing.T) {
@@ -475,3 +648,44 @@ func TestCloseIdleConnections(t *testing.T) {
 	client.CloseIdleConnections()
 	assert.Equal(t, true, transport.closed)
 }
+
+func TestCloseWaitsForPushesInFlight(t *testing.T) {
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+	}))
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	pushed := make(chan struct{})
+	go func() {
+		_, err := client.Push(mockNotification())
+		assert.NoError(t, err)
+		close(pushed)
+	}()
+	<-received
+
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the push finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(unblock)
+	<-pushed
+	<-closed
+}
+
+func TestCloseWithoutPushes(t *testing.T) {
+	transport := &mockTransport{}
+	client := mockClient("")
+	client.HTTPClient.Transport = transport
+	client.Close()
+	assert.True(t, transport.closed)
+}
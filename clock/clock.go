@@ -0,0 +1,19 @@
+// Package clock provides the Clock used by apns2 and token to read the
+// current time, so that it can be replaced in tests.
+package clock
+
+import "time"
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the Clock which tells the system time.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
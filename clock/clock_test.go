@@ -0,0 +1,18 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Unit Tests
+
+func TestRealClock(t *testing.T) {
+	before := time.Now()
+	now := clock.Real.Now()
+	assert.False(t, now.Before(before))
+	assert.False(t, now.After(time.Now()))
+}
@@ -0,0 +1,451 @@
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// streamLimitCheckInterval is how often the connection states are checked for
+// a raised stream limit while pushes are waiting for a free stream.
+var streamLimitCheckInterval = 10 * time.Millisecond
+
+// ErrNoConnAvailable is returned by the ConnPool when none of its connections
+// can take a new request and no replacement connection is being dialed.
+var ErrNoConnAvailable = errors.New("apns2: no connection available in pool")
+
+// ConnPool is an http2.ClientConnPool which keeps a fixed number of HTTP/2
+// connections open to each APNs host and spreads requests across them.
+//
+// Connections are dialed lazily on the first request. Each request is sent on
+// the least loaded connection, and connections which have been closed or have
+// received a GOAWAY from the APNs are replaced with a new connection on the
+// next request.
+//
+// The Transport should have StrictMaxConcurrentStreams set, so that requests
+// queue on an existing connection once every connection in the pool is at the
+// limit advertised by the APNs, rather than failing.
+//
+// A Client using a ConnPool tracks the SETTINGS_MAX_CONCURRENT_STREAMS limit
+// advertised on each connection, and blocks pushes once every stream to the
+// host is in use. Blocked pushes are woken when a stream is released, or when
+// the APNs raises its limit, which is checked every streamLimitCheckInterval
+// while pushes are waiting. See StreamStats for the current capacity and
+// queue depth.
+type ConnPool struct {
+	// Size is the number of connections kept open to each host. If Size is
+	// zero or negative, a single connection is used.
+	Size int
+
+	// Transport is the http2.Transport used to create connections. Its
+	// TLSClientConfig and DialTLS fields are used when dialing.
+	Transport *http2.Transport
+
+	mu       sync.Mutex
+	hosts    map[string]*poolHost
+	dialed   chan struct{}
+	watching bool
+}
+
+// StreamStats is a snapshot of the stream usage of a ConnPool, for one host or
+// across all hosts.
+type StreamStats struct {
+	// Conns is the number of open connections in the pool.
+	Conns int
+
+	// MaxConcurrentStreams is the number of concurrent streams currently
+	// allowed by the APNs across all connections in the pool. Connections
+	// which have not yet received a SETTINGS frame count as a single stream.
+	MaxConcurrentStreams int
+
+	// InFlight is the number of pushes currently being sent.
+	InFlight int
+
+	// Queued is the number of pushes waiting for a free stream.
+	Queued int
+}
+
+// poolHost is the connections and stream usage of the pool for one address.
+type poolHost struct {
+	slots    []*poolSlot
+	inFlight int
+	queued   int
+	released chan struct{}
+}
+
+type poolSlot struct {
+	conn *http2.ClientConn
+	dial *poolDial
+}
+
+type poolDial struct {
+	err error
+}
+
+// GetClientConn returns the least loaded connection to addr, dialing new
+// connections until the pool is full. It implements http2.ClientConnPool.
+//
+// If no connection is open, GetClientConn waits for the first dial to finish.
+// A dial error is only returned once no other connection to addr is open or
+// being dialed.
+func (p *ConnPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	var waited []*poolDial
+	var dialErr error
+	for {
+		p.mu.Lock()
+		for _, call := range waited {
+			if call.err != nil {
+				dialErr = call.err
+			}
+		}
+		waited = waited[:0]
+		var best *http2.ClientConn
+		var bestLoad int
+		for _, s := range p.hostLocked(addr).slots {
+			if s.conn != nil && !s.conn.CanTakeNewRequest() {
+				s.conn = nil
+			}
+			if s.conn == nil {
+				// Slots which failed to dial while waiting are not redialed
+				// by this request, so a persistent dial error is returned
+				// rather than retried forever.
+				if s.dial == nil && dialErr == nil {
+					s.dial = p.startDialLocked(s, addr)
+				}
+				if s.dial != nil {
+					waited = append(waited, s.dial)
+				}
+				continue
+			}
+			if load := connLoad(s.conn); best == nil || load < bestLoad {
+				best, bestLoad = s.conn, load
+			}
+		}
+		if best != nil {
+			ok := best.ReserveNewRequest()
+			p.mu.Unlock()
+			if ok {
+				return best, nil
+			}
+			// The connection was closed or received a GOAWAY since it was
+			// checked, so look again.
+			continue
+		}
+		if len(waited) == 0 {
+			p.mu.Unlock()
+			if dialErr != nil {
+				return nil, dialErr
+			}
+			return nil, ErrNoConnAvailable
+		}
+		if p.dialed == nil {
+			p.dialed = make(chan struct{})
+		}
+		dialed := p.dialed
+		p.mu.Unlock()
+
+		select {
+		case <-dialed:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// MarkDead removes a connection from the pool. A replacement connection is
+// dialed on the next request. It implements http2.ClientConnPool.
+func (p *ConnPool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		for _, s := range h.slots {
+			if s.conn == cc {
+				s.conn = nil
+			}
+		}
+	}
+}
+
+// CloseIdleConnections closes any connections in the pool which are not
+// currently in use. It will not interrupt any connections currently in use.
+func (p *ConnPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		for _, s := range h.slots {
+			if s.conn == nil {
+				continue
+			}
+			if st := s.conn.State(); st.StreamsActive == 0 && st.StreamsReserved == 0 {
+				s.conn.Close()
+				s.conn = nil
+			}
+		}
+	}
+}
+
+// Stats returns a snapshot of the current stream usage of the pool, across all
+// hosts.
+func (p *ConnPool) Stats() StreamStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var stats StreamStats
+	for _, h := range p.hosts {
+		hs := h.statsLocked()
+		stats.Conns += hs.Conns
+		stats.MaxConcurrentStreams += hs.MaxConcurrentStreams
+		stats.InFlight += hs.InFlight
+		stats.Queued += hs.Queued
+	}
+	return stats
+}
+
+// hostStats returns a snapshot of the current stream usage of the connections
+// to addr.
+func (p *ConnPool) hostStats(addr string) StreamStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hostLocked(addr).statsLocked()
+}
+
+// acquire blocks until a stream to addr is available or ctx is done.
+func (p *ConnPool) acquire(ctx context.Context, addr string) error {
+	p.mu.Lock()
+	h := p.hostLocked(addr)
+	for {
+		if h.inFlight < h.capacityLocked() {
+			h.inFlight++
+			p.mu.Unlock()
+			return nil
+		}
+		if h.released == nil {
+			h.released = make(chan struct{})
+		}
+		released := h.released
+		h.queued++
+		p.watchLocked()
+		p.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			p.mu.Lock()
+			h.queued--
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+		p.mu.Lock()
+		h.queued--
+	}
+}
+
+// release returns a stream to addr taken by acquire and wakes any waiting
+// callers.
+func (p *ConnPool) release(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.hostLocked(addr)
+	h.inFlight--
+	h.wakeLocked()
+}
+
+// watchLocked starts checking the stream limits of the pool in the background
+// until no callers are waiting, if it is not already running. The http2
+// package does not report when the APNs changes its limit, so waiting callers
+// are woken once the connection state shows a free stream. p.mu must be held.
+func (p *ConnPool) watchLocked() {
+	if p.watching {
+		return
+	}
+	p.watching = true
+	go func() {
+		ticker := time.NewTicker(streamLimitCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.mu.Lock()
+			waiting := false
+			for _, h := range p.hosts {
+				if h.queued == 0 {
+					continue
+				}
+				waiting = true
+				if h.inFlight < h.capacityLocked() {
+					h.wakeLocked()
+				}
+			}
+			if !waiting {
+				p.watching = false
+				p.mu.Unlock()
+				return
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// requires p.mu is held.
+func (p *ConnPool) hostLocked(addr string) *poolHost {
+	if p.hosts == nil {
+		p.hosts = map[string]*poolHost{}
+	}
+	h, ok := p.hosts[addr]
+	if !ok {
+		h = &poolHost{slots: make([]*poolSlot, poolSize(p.Size))}
+		for i := range h.slots {
+			h.slots[i] = &poolSlot{}
+		}
+		p.hosts[addr] = h
+	}
+	return h
+}
+
+// requires the pool's mu is held.
+func (h *poolHost) wakeLocked() {
+	if h.released != nil {
+		close(h.released)
+		h.released = nil
+	}
+}
+
+// requires the pool's mu is held.
+func (h *poolHost) statsLocked() StreamStats {
+	conns, capacity := h.connsLocked()
+	return StreamStats{
+		Conns:                conns,
+		MaxConcurrentStreams: capacity,
+		InFlight:             h.inFlight,
+		Queued:               h.queued,
+	}
+}
+
+// requires the pool's mu is held.
+func (h *poolHost) capacityLocked() int {
+	_, capacity := h.connsLocked()
+	return capacity
+}
+
+// connsLocked returns the number of open connections and the number of
+// streams they allow. Slots without an open connection, and connections which
+// have not yet received a SETTINGS frame, count as a single stream. Requires
+// the pool's mu is held.
+func (h *poolHost) connsLocked() (conns, capacity int) {
+	for _, s := range h.slots {
+		if s.conn == nil {
+			capacity++
+			continue
+		}
+		st := s.conn.State()
+		if st.Closed || st.Closing {
+			capacity++
+			continue
+		}
+		conns++
+		if st.MaxConcurrentStreams == 0 {
+			capacity++
+		} else {
+			capacity += int(st.MaxConcurrentStreams)
+		}
+	}
+	return conns, capacity
+}
+
+// requires p.mu is held.
+func (p *ConnPool) startDialLocked(s *poolSlot, addr string) *poolDial {
+	call := &poolDial{}
+	go func() {
+		cc, err := p.dial(addr)
+		p.mu.Lock()
+		s.dial = nil
+		s.conn = cc
+		call.err = err
+		if p.dialed != nil {
+			close(p.dialed)
+			p.dialed = nil
+		}
+		p.mu.Unlock()
+	}()
+	return call
+}
+
+func (p *ConnPool) dial(addr string) (*http2.ClientConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{}
+	if p.Transport.TLSClientConfig != nil {
+		cfg = p.Transport.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if !containsString(cfg.NextProtos, http2.NextProtoTLS) {
+		cfg.NextProtos = append([]string{http2.NextProtoTLS}, cfg.NextProtos...)
+	}
+	dialTLS := p.Transport.DialTLS
+	if dialTLS == nil {
+		dialTLS = DialTLS
+	}
+	conn, err := dialTLS("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.Transport.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+func poolSize(size int) int {
+	if size <= 0 {
+		return 1
+	}
+	return size
+}
+
+func connLoad(cc *http2.ClientConn) int {
+	st := cc.State()
+	return st.StreamsActive + st.StreamsReserved + st.StreamsPending
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// poolAddr returns the address of the connections used for a request to u,
+// as passed to GetClientConn by the http2.Transport.
+func poolAddr(u *url.URL) string {
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host, port = u.Host, "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host + ":" + port
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// newPooledTransport configures t to use a ConnPool of the given size.
+func newPooledTransport(t *http2.Transport, size int) *http2.Transport {
+	t.StrictMaxConcurrentStreams = true
+	t.ConnPool = &ConnPool{Size: size, Transport: t}
+	return t
+}
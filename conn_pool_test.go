@@ -0,0 +1,311 @@
+package apns2_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockHTTP2Server(handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server
+}
+
+func mockHTTP2ServerWithMaxStreams(maxStreams uint32, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	http2.ConfigureServer(server.Config, &http2.Server{MaxConcurrentStreams: maxStreams})
+	server.TLS = &tls.Config{NextProtos: []string{http2.NextProtoTLS}}
+	server.StartTLS()
+	return server
+}
+
+func mockPooledClient(url string, size int) *apns.Client {
+	client := apns.NewPooledClient(mockCert(), size)
+	client.Host = url
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		return tls.Dial(network, addr, cfg)
+	}
+	return client
+}
+
+// Unit Tests
+
+func TestPooledClientTransport(t *testing.T) {
+	client := apns.NewPooledClient(mockCert(), 4)
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	assert.True(t, transport.StrictMaxConcurrentStreams)
+	assert.Equal(t, 4, transport.ConnPool.(*apns.ConnPool).Size)
+}
+
+func TestPooledTokenClientTransport(t *testing.T) {
+	client := apns.NewPooledTokenClient(mockToken(), 2)
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	assert.Equal(t, 2, transport.ConnPool.(*apns.ConnPool).Size)
+	assert.NotNil(t, client.Token)
+}
+
+// Functional Tests
+
+func TestPooledClientSpreadsConnections(t *testing.T) {
+	var mu sync.Mutex
+	addrs := map[string]bool{}
+	server := mockHTTP2Server(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		addrs[r.RemoteAddr] = true
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	})
+	defer server.Close()
+
+	client := mockPooledClient(server.URL, 3)
+	// The first round dials the connections, the second is spread over them.
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := client.Push(mockNotification())
+				if assert.NoError(t, err) {
+					assert.Equal(t, http.StatusOK, res.StatusCode)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	assert.Len(t, addrs, 3)
+}
+
+func TestPooledClientReplacesDeadConnections(t *testing.T) {
+	server := mockHTTP2Server(func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	client := mockPooledClient(server.URL, 1)
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+
+	server.CloseClientConnections()
+	time.Sleep(10 * time.Millisecond)
+
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestPooledClientDialErrorWithOtherConnections(t *testing.T) {
+	server := mockHTTP2Server(func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	client := mockPooledClient(server.URL, 2)
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	var mu sync.Mutex
+	dials := 0
+	transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		dials++
+		first := dials == 1
+		mu.Unlock()
+		if first {
+			return nil, errors.New("dial failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return tls.Dial(network, addr, cfg)
+	}
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestPooledClientDialError(t *testing.T) {
+	client := mockPooledClient("https://127.0.0.1:443", 2)
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		return nil, errors.New("dial failed")
+	}
+	res, err := client.Push(mockNotification())
+	assert.Nil(t, res)
+	assert.Contains(t, err.Error(), "dial failed")
+}
+
+func TestPooledClientCloseIdleConnections(t *testing.T) {
+	var mu sync.Mutex
+	addrs := map[string]bool{}
+	server := mockHTTP2Server(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		addrs[r.RemoteAddr] = true
+		mu.Unlock()
+	})
+	defer server.Close()
+
+	client := mockPooledClient(server.URL, 1)
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	client.CloseIdleConnections()
+	_, err = client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 2)
+}
+
+func TestPooledClientBlocksAtStreamLimit(t *testing.T) {
+	unblock := make(chan struct{})
+	server := mockHTTP2ServerWithMaxStreams(2, func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	})
+	defer server.Close()
+
+	client := mockPooledClient(server.URL, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Push(mockNotification())
+			assert.NoError(t, err)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		stats := client.StreamStats()
+		return stats.InFlight == 2 && stats.Queued == 3
+	}, time.Second, time.Millisecond)
+	stats := client.StreamStats()
+	assert.Equal(t, 1, stats.Conns)
+	assert.Equal(t, 2, stats.MaxConcurrentStreams)
+
+	close(unblock)
+	wg.Wait()
+	assert.Equal(t, 0, client.StreamStats().InFlight)
+	assert.Equal(t, 0, client.StreamStats().Queued)
+}
+
+func TestPooledClientStreamLimitContextTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := mockHTTP2ServerWithMaxStreams(1, func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	})
+	defer server.Close()
+	defer close(unblock)
+
+	client := mockPooledClient(server.URL, 1)
+	go client.Push(mockNotification())
+	assert.Eventually(t, func() bool {
+		return client.StreamStats().InFlight == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, err := client.PushWithContext(ctx, mockNotification())
+	assert.Nil(t, res)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, client.StreamStats().Queued)
+}
+
+func TestClientWakesOnSettings(t *testing.T) {
+	unblock := make(chan struct{})
+	server := mockHTTP2ServerWithMaxStreams(5, func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	})
+	defer server.Close()
+
+	// Until the APNs SETTINGS frame arrives only one stream is allowed, and
+	// the first push does not complete until unblocked, so the other pushes
+	// are only sent once the new limit wakes them.
+	client := mockPooledClient(server.URL, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Push(mockNotification())
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		return client.StreamStats().InFlight == 5
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 5, client.StreamStats().MaxConcurrentStreams)
+	close(unblock)
+	wg.Wait()
+}
+
+func TestPooledClientLimitsStreamsPerHost(t *testing.T) {
+	sandbox := mockHTTP2ServerWithMaxStreams(5, func(w http.ResponseWriter, r *http.Request) {})
+	defer sandbox.Close()
+	unblock := make(chan struct{})
+	production := mockHTTP2ServerWithMaxStreams(1, func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	})
+	defer production.Close()
+
+	client := mockPooledClient(sandbox.URL, 1)
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, 5, client.StreamStats().MaxConcurrentStreams)
+
+	// The idle connection to the first host does not add to the limit of
+	// the second.
+	client.Host = production.URL
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Push(mockNotification())
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		stats := client.StreamStats()
+		return stats.MaxConcurrentStreams == 1 && stats.InFlight == 1 && stats.Queued == 2
+	}, time.Second, time.Millisecond)
+	close(unblock)
+	wg.Wait()
+}
+
+func TestClientStreamStats(t *testing.T) {
+	server := mockHTTP2ServerWithMaxStreams(3, func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	client := apns.NewClient(mockCert())
+	client.Host = server.URL
+	assert.Equal(t, apns.StreamStats{MaxConcurrentStreams: 1}, client.StreamStats())
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		return tls.Dial(network, addr, cfg)
+	}
+	_, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns.StreamStats{Conns: 1, MaxConcurrentStreams: 3}, client.StreamStats())
+}
+
+func TestTokenClientUsesConnPool(t *testing.T) {
+	client := apns.NewTokenClient(mockToken())
+	transport := client.HTTPClient.Transport.(*http2.Transport)
+	assert.Equal(t, 1, transport.ConnPool.(*apns.ConnPool).Size)
+	assert.True(t, transport.StrictMaxConcurrentStreams)
+}
+
+func TestClientStreamStatsWithoutPool(t *testing.T) {
+	client := apns.NewClient(mockCert())
+	client.HTTPClient.Transport = &http2.Transport{}
+	assert.Equal(t, apns.StreamStats{}, client.StreamStats())
+}
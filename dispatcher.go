@@ -0,0 +1,265 @@
+package apns2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default Dispatcher settings used by NewDispatcher.
+var (
+	// DefaultDispatcherWorkers is the number of workers used if workers is
+	// zero.
+	DefaultDispatcherWorkers = 50
+
+	// DefaultDispatcherQueueSize is the size of the queue used if queueSize
+	// is zero.
+	DefaultDispatcherQueueSize = 1000
+)
+
+// ErrDispatcherClosed is returned when a notification is enqueued on a
+// Dispatcher which has been shut down.
+var ErrDispatcherClosed = errors.New("apns2: dispatcher is shut down")
+
+// ErrNoResponse is the error of a notification whose Pusher returned neither
+// a Response nor an error.
+var ErrNoResponse = errors.New("apns2: pusher returned no response")
+
+// Dispatcher sends notifications asynchronously using a bounded in-memory
+// queue and a fixed number of workers. Results are delivered through a
+// Future or a callback.
+//
+// A Dispatcher must be created with NewDispatcher and should be shut down
+// with Shutdown once it is no longer needed.
+type Dispatcher struct {
+	// Counters are accessed atomically and kept first for 64-bit alignment.
+	enqueued int64
+	inFlight int64
+	sent     int64
+	rejected int64
+	failed   int64
+
+	pusher  Pusher
+	queue   chan *dispatch
+	closing chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	once    sync.Once
+	started time.Time
+}
+
+// DispatcherStats is a snapshot of the state and throughput of a Dispatcher.
+type DispatcherStats struct {
+	// QueueDepth is the number of notifications waiting to be sent.
+	QueueDepth int
+
+	// InFlight is the number of notifications currently being sent.
+	InFlight int
+
+	// Enqueued is the total number of notifications enqueued.
+	Enqueued int64
+
+	// Sent is the total number of notifications accepted by the APNs.
+	Sent int64
+
+	// Rejected is the total number of notifications rejected by the APNs.
+	Rejected int64
+
+	// Failed is the total number of notifications which failed with an
+	// error.
+	Failed int64
+
+	// Throughput is the average number of notifications completed per
+	// second since the Dispatcher was created.
+	Throughput float64
+}
+
+// Future is the pending result of a notification enqueued on a Dispatcher.
+type Future struct {
+	done chan struct{}
+	res  *Response
+	err  error
+}
+
+type dispatch struct {
+	n        *Notification
+	future   *Future
+	callback func(n *Notification, res *Response, err error)
+}
+
+// NewDispatcher returns a new Dispatcher which sends notifications using the
+// pusher, such as a Client or Router, with the given number of workers and
+// queue size. If workers or queueSize are zero, DefaultDispatcherWorkers and
+// DefaultDispatcherQueueSize are used.
+func NewDispatcher(pusher Pusher, workers, queueSize int) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultDispatcherWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultDispatcherQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		pusher:  pusher,
+		queue:   make(chan *dispatch, queueSize),
+		closing: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		started: time.Now(),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Enqueue adds a notification to the queue and returns a Future for its
+// result. If the queue is full, Enqueue blocks until there is space or the
+// context is done. The context only applies to enqueuing; the notification
+// is sent with the Dispatcher's own context.
+func (d *Dispatcher) Enqueue(ctx Context, n *Notification) (*Future, error) {
+	f := &Future{done: make(chan struct{})}
+	if err := d.enqueue(ctx, &dispatch{n: n, future: f}); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// PushWithContext enqueues a notification and waits for its result, so that a
+// Dispatcher can be used as a Pusher. The context applies to enqueuing and to
+// waiting, but the notification is still sent if the context is done after
+// it has been enqueued.
+func (d *Dispatcher) PushWithContext(ctx Context, n *Notification) (*Response, error) {
+	f, err := d.Enqueue(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return f.Wait(ctx)
+}
+
+// EnqueueFunc adds a notification to the queue, and calls callback with its
+// result once it has been sent. The callback is called from a worker
+// goroutine and should not block. Like Enqueue, EnqueueFunc blocks while the
+// queue is full.
+func (d *Dispatcher) EnqueueFunc(ctx Context, n *Notification, callback func(n *Notification, res *Response, err error)) error {
+	return d.enqueue(ctx, &dispatch{n: n, callback: callback})
+}
+
+// Shutdown stops the Dispatcher from accepting new notifications and waits
+// for queued and in-flight notifications to be sent. If the context is done
+// first, in-flight pushes are canceled, any notifications still queued
+// complete with an error, and the context's error is returned.
+func (d *Dispatcher) Shutdown(ctx Context) error {
+	d.once.Do(func() {
+		close(d.closing)
+		d.mu.Lock()
+		d.closed = true
+		close(d.queue)
+		d.mu.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the state and throughput of the Dispatcher.
+func (d *Dispatcher) Stats() DispatcherStats {
+	stats := DispatcherStats{
+		QueueDepth: len(d.queue),
+		InFlight:   int(atomic.LoadInt64(&d.inFlight)),
+		Enqueued:   atomic.LoadInt64(&d.enqueued),
+		Sent:       atomic.LoadInt64(&d.sent),
+		Rejected:   atomic.LoadInt64(&d.rejected),
+		Failed:     atomic.LoadInt64(&d.failed),
+	}
+	if elapsed := time.Since(d.started).Seconds(); elapsed > 0 {
+		stats.Throughput = float64(stats.Sent+stats.Rejected+stats.Failed) / elapsed
+	}
+	return stats
+}
+
+func (d *Dispatcher) enqueue(ctx Context, item *dispatch) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+	select {
+	case d.queue <- item:
+		atomic.AddInt64(&d.enqueued, 1)
+		return nil
+	case <-d.closing:
+		return ErrDispatcherClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for item := range d.queue {
+		atomic.AddInt64(&d.inFlight, 1)
+		res, err := d.pusher.PushWithContext(d.ctx, item.n)
+		atomic.AddInt64(&d.inFlight, -1)
+		if res == nil && err == nil {
+			err = ErrNoResponse
+		}
+		switch {
+		case err != nil:
+			atomic.AddInt64(&d.failed, 1)
+		case res.Sent():
+			atomic.AddInt64(&d.sent, 1)
+		default:
+			atomic.AddInt64(&d.rejected, 1)
+		}
+		if item.future != nil {
+			item.future.res, item.future.err = res, err
+			close(item.future.done)
+		}
+		if item.callback != nil {
+			item.callback(item.n, res, err)
+		}
+	}
+}
+
+// Done returns a channel which is closed once the notification has been
+// sent or has failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the notification to be sent and returns its Response, or
+// an error if it could not be sent.
+func (f *Future) Result() (*Response, error) {
+	<-f.done
+	return f.res, f.err
+}
+
+// Wait is like Result, but stops waiting and returns the context's error if
+// the context is done first. The notification is still sent.
+func (f *Future) Wait(ctx Context) (*Response, error) {
+	select {
+	case <-f.done:
+		return f.res, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
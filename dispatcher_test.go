@@ -0,0 +1,203 @@
+package apns2_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/stretchr/testify/assert"
+)
+
+// Unit Tests
+
+func TestDispatcherEnqueueAfterShutdown(t *testing.T) {
+	dispatcher := apns.NewDispatcher(mockClient(""), 1, 1)
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+
+	f, err := dispatcher.Enqueue(context.Background(), mockNotification())
+	assert.Nil(t, f)
+	assert.Equal(t, apns.ErrDispatcherClosed, err)
+	err = dispatcher.EnqueueFunc(context.Background(), mockNotification(), nil)
+	assert.Equal(t, apns.ErrDispatcherClosed, err)
+}
+
+func TestDispatcherShutdownTwice(t *testing.T) {
+	dispatcher := apns.NewDispatcher(mockClient(""), 0, 0)
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+}
+
+// Functional Tests
+
+func TestDispatcherEnqueue(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	dispatcher := apns.NewDispatcher(mockClient(server.URL), 2, 10)
+	defer dispatcher.Shutdown(context.Background())
+
+	var futures []*apns.Future
+	for _, n := range mockBatchNotifications("a1", "bad", "a2") {
+		f, err := dispatcher.Enqueue(context.Background(), n)
+		assert.NoError(t, err)
+		futures = append(futures, f)
+	}
+	res, err := futures[0].Result()
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	res, err = futures[1].Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, apns.ReasonBadDeviceToken, res.Reason)
+	<-futures[2].Done()
+
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(3), stats.Enqueued)
+	assert.Equal(t, int64(2), stats.Sent)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.True(t, stats.Throughput > 0)
+}
+
+func TestDispatcherEnqueueFunc(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	dispatcher := apns.NewDispatcher(mockClient(server.URL), 2, 10)
+	var mu sync.Mutex
+	reasons := map[string]string{}
+	for _, n := range mockBatchNotifications("a1", "bad", "gone") {
+		err := dispatcher.EnqueueFunc(context.Background(), n, func(n *apns.Notification, res *apns.Response, err error) {
+			assert.NoError(t, err)
+			mu.Lock()
+			reasons[n.DeviceToken] = res.Reason
+			mu.Unlock()
+		})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+	assert.Equal(t, map[string]string{"a1": "", "bad": apns.ReasonBadDeviceToken, "gone": apns.ReasonUnregistered}, reasons)
+}
+
+func TestDispatcherShutdownDrainsQueue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	dispatcher := apns.NewDispatcher(mockClient(server.URL), 1, 10)
+	var futures []*apns.Future
+	for i := 0; i < 5; i++ {
+		f, err := dispatcher.Enqueue(context.Background(), mockNotification())
+		assert.NoError(t, err)
+		futures = append(futures, f)
+	}
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+	for _, f := range futures {
+		res, err := f.Result()
+		assert.NoError(t, err)
+		assert.True(t, res.Sent())
+	}
+	assert.Equal(t, 0, dispatcher.Stats().QueueDepth)
+	assert.Equal(t, 0, dispatcher.Stats().InFlight)
+}
+
+func TestDispatcherShutdownContextTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	dispatcher := apns.NewDispatcher(mockClient(server.URL), 1, 10)
+	f1, _ := dispatcher.Enqueue(context.Background(), mockNotification())
+	f2, _ := dispatcher.Enqueue(context.Background(), mockNotification())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, dispatcher.Shutdown(ctx))
+	_, err := f1.Result()
+	assert.Error(t, err)
+	_, err = f2.Result()
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), dispatcher.Stats().Failed)
+}
+
+func TestDispatcherEnqueueBlocksWhenQueueFull(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+
+	dispatcher := apns.NewDispatcher(mockClient(server.URL), 1, 1)
+	_, err := dispatcher.Enqueue(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return dispatcher.Stats().InFlight == 1
+	}, time.Second, time.Millisecond)
+	_, err = dispatcher.Enqueue(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dispatcher.Stats().QueueDepth)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = dispatcher.Enqueue(ctx, mockNotification())
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(unblock)
+	assert.NoError(t, dispatcher.Shutdown(context.Background()))
+	assert.Equal(t, int64(2), dispatcher.Stats().Sent)
+}
+
+func TestDispatcherPushWithContext(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	var pusher apns.Pusher = apns.NewDispatcher(mockClient(server.URL), 1, 10)
+	res, err := pusher.PushWithContext(context.Background(), mockBatchNotifications("bad")[0])
+	assert.NoError(t, err)
+	assert.Equal(t, apns.ReasonBadDeviceToken, res.Reason)
+
+	assert.NoError(t, pusher.(*apns.Dispatcher).Shutdown(context.Background()))
+	_, err = pusher.PushWithContext(context.Background(), mockNotification())
+	assert.Equal(t, apns.ErrDispatcherClosed, err)
+}
+
+func TestDispatcherWithRouter(t *testing.T) {
+	server := mockBatchServer()
+	defer server.Close()
+
+	router := apns.NewRouter()
+	router.Handle("*", mockClient(server.URL))
+	dispatcher := apns.NewDispatcher(router, 1, 10)
+	defer dispatcher.Shutdown(context.Background())
+	f, err := dispatcher.Enqueue(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	res, err := f.Result()
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+}
+
+func TestDispatcherPusherWithoutResponse(t *testing.T) {
+	pusher := apns2test.NewFakePusher()
+	pusher.Respond = func(n *apns.Notification) (*apns.Response, error) {
+		return nil, nil
+	}
+	dispatcher := apns.NewDispatcher(pusher, 1, 10)
+	defer dispatcher.Shutdown(context.Background())
+	f, err := dispatcher.Enqueue(context.Background(), mockNotification())
+	assert.NoError(t, err)
+	res, err := f.Result()
+	assert.Nil(t, res)
+	assert.Equal(t, apns.ErrNoResponse, err)
+	assert.Equal(t, int64(1), dispatcher.Stats().Failed)
+}
@@ -0,0 +1,204 @@
+package apns2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ReasonError is returned by Response.Err when a notification was rejected by
+// the APNs. It can be compared to the Err sentinel values below with
+// errors.Is, which matches on the Reason.
+type ReasonError struct {
+	// The HTTP status code returned by APNs.
+	StatusCode int
+
+	// The APNs error string indicating the reason for the rejection. See the
+	// Reason constants.
+	Reason string
+
+	// The ApnsID of the rejected notification.
+	ApnsID string
+
+	// If the value of StatusCode is 410, this is the last time at which APNs
+	// confirmed that the device token was no longer valid for the topic.
+	Timestamp Time
+}
+
+func (e *ReasonError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("apns2: notification rejected with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("apns2: notification rejected with status %d: %s", e.StatusCode, e.Reason)
+}
+
+// Is reports whether target is a *ReasonError with the same Reason, so that
+// errors.Is(err, ErrUnregistered) matches any Unregistered rejection.
+func (e *ReasonError) Is(target error) bool {
+	t, ok := target.(*ReasonError)
+	return ok && t.Reason == e.Reason
+}
+
+// ResponseError is returned by Push when a response was received from the
+// APNs, or an intermediary such as a proxy, but its body could not be decoded.
+// It keeps the status code, headers and the start of the raw body, to help
+// diagnose the failure.
+type ResponseError struct {
+	// The HTTP status code of the response.
+	StatusCode int
+
+	// The apns-id and apns-unique-id headers of the response, if any.
+	ApnsID       string
+	ApnsUniqueID string
+
+	// All of the headers of the response.
+	Header http.Header
+
+	// The raw response body, up to MaxResponseBodySize bytes.
+	Body []byte
+
+	// The error which occurred reading or decoding the body.
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("apns2: invalid response with status %d: %v", e.StatusCode, e.Err)
+}
+
+// Unwrap returns the error which occurred reading or decoding the body.
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Sentinel errors for each of the Reason values, for use with errors.Is.
+var (
+	ErrBadCollapseID               = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadCollapseID}
+	ErrBadDeviceToken              = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadDeviceToken}
+	ErrBadExpirationDate           = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadExpirationDate}
+	ErrBadMessageID                = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadMessageID}
+	ErrBadPriority                 = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadPriority}
+	ErrBadTopic                    = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonBadTopic}
+	ErrDeviceTokenNotForTopic      = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonDeviceTokenNotForTopic}
+	ErrDuplicateHeaders            = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonDuplicateHeaders}
+	ErrIdleTimeout                 = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonIdleTimeout}
+	ErrInvalidPushType             = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonInvalidPushType}
+	ErrMissingDeviceToken          = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonMissingDeviceToken}
+	ErrMissingTopic                = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonMissingTopic}
+	ErrPayloadEmpty                = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonPayloadEmpty}
+	ErrTopicDisallowed             = &ReasonError{StatusCode: http.StatusBadRequest, Reason: ReasonTopicDisallowed}
+	ErrBadCertificate              = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonBadCertificate}
+	ErrBadCertificateEnvironment   = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonBadCertificateEnvironment}
+	ErrExpiredProviderToken        = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonExpiredProviderToken}
+	ErrForbidden                   = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonForbidden}
+	ErrInvalidProviderToken        = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonInvalidProviderToken}
+	ErrMissingProviderToken        = &ReasonError{StatusCode: http.StatusForbidden, Reason: ReasonMissingProviderToken}
+	ErrBadPath                     = &ReasonError{StatusCode: http.StatusNotFound, Reason: ReasonBadPath}
+	ErrMethodNotAllowed            = &ReasonError{StatusCode: http.StatusMethodNotAllowed, Reason: ReasonMethodNotAllowed}
+	ErrExpiredToken                = &ReasonError{StatusCode: http.StatusGone, Reason: ReasonExpiredToken}
+	ErrUnregistered                = &ReasonError{StatusCode: http.StatusGone, Reason: ReasonUnregistered}
+	ErrPayloadTooLarge             = &ReasonError{StatusCode: http.StatusRequestEntityTooLarge, Reason: ReasonPayloadTooLarge}
+	ErrTooManyProviderTokenUpdates = &ReasonError{StatusCode: http.StatusTooManyRequests, Reason: ReasonTooManyProviderTokenUpdates}
+	ErrTooManyRequests             = &ReasonError{StatusCode: http.StatusTooManyRequests, Reason: ReasonTooManyRequests}
+	ErrInternalServerError         = &ReasonError{StatusCode: http.StatusInternalServerError, Reason: ReasonInternalServerError}
+	ErrServiceUnavailable          = &ReasonError{StatusCode: http.StatusServiceUnavailable, Reason: ReasonServiceUnavailable}
+	ErrShutdown                    = &ReasonError{StatusCode: http.StatusServiceUnavailable, Reason: ReasonShutdown}
+)
+
+type reasonClass uint8
+
+const (
+	classRetryable reasonClass = 1 << iota
+	classDeleteToken
+	classCredential
+	classPayload
+)
+
+// reasonClasses classifies every Reason returned by the APNs.
+var reasonClasses = map[string]reasonClass{
+	ReasonBadCollapseID:               classPayload,
+	ReasonBadDeviceToken:              classDeleteToken,
+	ReasonBadExpirationDate:           classPayload,
+	ReasonBadMessageID:                classPayload,
+	ReasonBadPriority:                 classPayload,
+	ReasonBadTopic:                    classPayload,
+	ReasonDeviceTokenNotForTopic:      0,
+	ReasonDuplicateHeaders:            classPayload,
+	ReasonIdleTimeout:                 classRetryable,
+	ReasonInvalidPushType:             classPayload,
+	ReasonMissingDeviceToken:          classPayload,
+	ReasonMissingTopic:                classPayload,
+	ReasonPayloadEmpty:                classPayload,
+	ReasonTopicDisallowed:             classCredential,
+	ReasonBadCertificate:              classCredential,
+	ReasonBadCertificateEnvironment:   classCredential,
+	ReasonExpiredProviderToken:        classCredential | classRetryable,
+	ReasonForbidden:                   classCredential,
+	ReasonInvalidProviderToken:        classCredential,
+	ReasonMissingProviderToken:        classCredential,
+	ReasonBadPath:                     classPayload,
+	ReasonMethodNotAllowed:            classPayload,
+	ReasonExpiredToken:                classDeleteToken,
+	ReasonUnregistered:                classDeleteToken,
+	ReasonPayloadTooLarge:             classPayload,
+	ReasonTooManyProviderTokenUpdates: classCredential | classRetryable,
+	ReasonTooManyRequests:             classRetryable,
+	ReasonInternalServerError:         classRetryable,
+	ReasonServiceUnavailable:          classRetryable,
+	ReasonShutdown:                    classRetryable,
+}
+
+// IsRetryable reports whether err is a rejection which may succeed if the
+// notification is sent again later, such as TooManyRequests or
+// ServiceUnavailable. ExpiredProviderToken is retryable once a new provider
+// token has been generated. Unknown reasons, and a *ResponseError, are
+// retryable if the status code is 429 or 5xx.
+func IsRetryable(err error) bool {
+	var e *ReasonError
+	if errors.As(err, &e) {
+		if class, ok := reasonClasses[e.Reason]; ok {
+			return class&classRetryable != 0
+		}
+		return retryableStatus(e.StatusCode)
+	}
+	var r *ResponseError
+	return errors.As(err, &r) && retryableStatus(r.StatusCode)
+}
+
+// IsPermanent reports whether err is a rejection which will not succeed if
+// the same notification is sent again.
+func IsPermanent(err error) bool {
+	var e *ReasonError
+	return errors.As(err, &e) && !IsRetryable(err)
+}
+
+// ShouldDeleteToken reports whether err is a rejection which means the device
+// token is no longer valid and should no longer be used, such as
+// Unregistered, ExpiredToken or BadDeviceToken.
+//
+// BadDeviceToken is also returned when a device token is sent to the wrong
+// environment, so make sure the Client is using the correct Host.
+func ShouldDeleteToken(err error) bool {
+	return hasReasonClass(err, classDeleteToken)
+}
+
+// IsCredentialProblem reports whether err is a rejection caused by the
+// certificate or provider token used to connect, such as BadCertificate,
+// InvalidProviderToken or TopicDisallowed.
+func IsCredentialProblem(err error) bool {
+	return hasReasonClass(err, classCredential)
+}
+
+// IsPayloadProblem reports whether err is a rejection caused by the contents
+// of the notification, such as PayloadTooLarge, BadPriority or BadTopic.
+func IsPayloadProblem(err error) bool {
+	return hasReasonClass(err, classPayload)
+}
+
+func hasReasonClass(err error, class reasonClass) bool {
+	var e *ReasonError
+	return errors.As(err, &e) && reasonClasses[e.Reason]&class != 0
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
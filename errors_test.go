@@ -0,0 +1,94 @@
+package apns2_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseErrSent(t *testing.T) {
+	assert.NoError(t, (&apns.Response{StatusCode: 200}).Err())
+}
+
+func TestResponseErr(t *testing.T) {
+	res := &apns.Response{StatusCode: 410, Reason: apns.ReasonUnregistered, ApnsID: "9F595474-356C-485E-B67F-9870BAE68702"}
+	err := res.Err()
+	assert.EqualError(t, err, "apns2: notification rejected with status 410: Unregistered")
+	assert.True(t, errors.Is(err, apns.ErrUnregistered))
+	assert.False(t, errors.Is(err, apns.ErrExpiredToken))
+
+	var reasonErr *apns.ReasonError
+	assert.True(t, errors.As(fmt.Errorf("push failed: %w", err), &reasonErr))
+	assert.Equal(t, 410, reasonErr.StatusCode)
+	assert.Equal(t, res.ApnsID, reasonErr.ApnsID)
+}
+
+func TestResponseErrWithoutReason(t *testing.T) {
+	err := (&apns.Response{StatusCode: 502}).Err()
+	assert.EqualError(t, err, "apns2: notification rejected with status 502")
+	assert.True(t, apns.IsRetryable(err))
+}
+
+func TestReasonClassification(t *testing.T) {
+	scenarios := []struct {
+		err                                                 error
+		retryable, permanent, deleteToken, credential, body bool
+	}{
+		{apns.ErrBadCollapseID, false, true, false, false, true},
+		{apns.ErrBadDeviceToken, false, true, true, false, false},
+		{apns.ErrBadExpirationDate, false, true, false, false, true},
+		{apns.ErrBadMessageID, false, true, false, false, true},
+		{apns.ErrBadPriority, false, true, false, false, true},
+		{apns.ErrBadTopic, false, true, false, false, true},
+		{apns.ErrDeviceTokenNotForTopic, false, true, false, false, false},
+		{apns.ErrDuplicateHeaders, false, true, false, false, true},
+		{apns.ErrIdleTimeout, true, false, false, false, false},
+		{apns.ErrInvalidPushType, false, true, false, false, true},
+		{apns.ErrMissingDeviceToken, false, true, false, false, true},
+		{apns.ErrMissingTopic, false, true, false, false, true},
+		{apns.ErrPayloadEmpty, false, true, false, false, true},
+		{apns.ErrTopicDisallowed, false, true, false, true, false},
+		{apns.ErrBadCertificate, false, true, false, true, false},
+		{apns.ErrBadCertificateEnvironment, false, true, false, true, false},
+		{apns.ErrExpiredProviderToken, true, false, false, true, false},
+		{apns.ErrForbidden, false, true, false, true, false},
+		{apns.ErrInvalidProviderToken, false, true, false, true, false},
+		{apns.ErrMissingProviderToken, false, true, false, true, false},
+		{apns.ErrBadPath, false, true, false, false, true},
+		{apns.ErrMethodNotAllowed, false, true, false, false, true},
+		{apns.ErrExpiredToken, false, true, true, false, false},
+		{apns.ErrUnregistered, false, true, true, false, false},
+		{apns.ErrPayloadTooLarge, false, true, false, false, true},
+		{apns.ErrTooManyProviderTokenUpdates, true, false, false, true, false},
+		{apns.ErrTooManyRequests, true, false, false, false, false},
+		{apns.ErrInternalServerError, true, false, false, false, false},
+		{apns.ErrServiceUnavailable, true, false, false, false, false},
+		{apns.ErrShutdown, true, false, false, false, false},
+	}
+	for _, s := range scenarios {
+		reason := s.err.(*apns.ReasonError).Reason
+		assert.Equal(t, s.retryable, apns.IsRetryable(s.err), reason)
+		assert.Equal(t, s.permanent, apns.IsPermanent(s.err), reason)
+		assert.Equal(t, s.deleteToken, apns.ShouldDeleteToken(s.err), reason)
+		assert.Equal(t, s.credential, apns.IsCredentialProblem(s.err), reason)
+		assert.Equal(t, s.body, apns.IsPayloadProblem(s.err), reason)
+	}
+}
+
+func TestReasonClassificationOtherErrors(t *testing.T) {
+	for _, err := range []error{nil, errors.New("connection reset")} {
+		assert.False(t, apns.IsRetryable(err))
+		assert.False(t, apns.IsPermanent(err))
+		assert.False(t, apns.ShouldDeleteToken(err))
+		assert.False(t, apns.IsCredentialProblem(err))
+		assert.False(t, apns.IsPayloadProblem(err))
+	}
+}
+
+func TestReasonClassificationUnknownReason(t *testing.T) {
+	assert.True(t, apns.IsRetryable(&apns.ReasonError{StatusCode: 503, Reason: "Unknown"}))
+	assert.True(t, apns.IsPermanent(&apns.ReasonError{StatusCode: 400, Reason: "Unknown"}))
+}
@@ -2,6 +2,7 @@ package apns2
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -93,6 +94,56 @@ const (
 	PriorityHigh = 10
 )
 
+// Limits enforced by the APNs, which are checked by Notification.Validate.
+const (
+	// MaxPayloadSize is the maximum size in bytes of a notification payload.
+	MaxPayloadSize = 4096
+
+	// MaxVOIPPayloadSize is the maximum size in bytes of a VoIP notification
+	// payload.
+	MaxVOIPPayloadSize = 5120
+
+	// MaxCollapseIDSize is the maximum size in bytes of a collapse identifier.
+	MaxCollapseIDSize = 64
+)
+
+// pushTypeTopicSuffixes are the suffixes which must be appended to the app's
+// bundle ID in the topic for each push type.
+var pushTypeTopicSuffixes = map[EPushType]string{
+	PushTypeLocation:     ".location-query",
+	PushTypeVOIP:         ".voip",
+	PushTypeComplication: ".complication",
+	PushTypeFileProvider: ".pushkit.fileprovider",
+	PushTypeLiveActivity: ".push-type.liveactivity",
+	PushTypePushToTalk:   ".voip-ptt",
+}
+
+// ValidationError is returned by Notification.Validate when a notification
+// would be rejected by the APNs. It wraps the *ReasonError sentinel for the
+// Reason the APNs would reject it with, so it can be checked with errors.Is
+// and the classification helpers, such as IsPayloadProblem.
+type ValidationError struct {
+	// Field is the name of the invalid Notification field.
+	Field string
+
+	// Message describes why the field is invalid.
+	Message string
+
+	// Err is the *ReasonError sentinel for the Reason the APNs would reject
+	// the notification with.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("apns2: invalid notification %s: %s", e.Field, e.Message)
+}
+
+// Unwrap returns the *ReasonError sentinel for the Reason the APNs would
+// reject the notification with.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
 // Notification represents the the data and metadata for a APNs Remote Notification.
 type Notification struct {
 
@@ -160,3 +211,110 @@ func (n *Notification) MarshalJSON() ([]byte, error) {
 		return json.Marshal(payload)
 	}
 }
+
+// Validate checks the notification against the rules enforced by the APNs for
+// its push type, and returns a *ValidationError describing the first problem
+// found. It checks the device token format, the ApnsID UUID format, the
+// collapse identifier and payload sizes, the priority, and that the topic has
+// the suffix required by the push type.
+//
+// Validation catches notifications which would be rejected with a 400 or 413
+// status before they are sent. See Client.ValidateNotifications to validate
+// every notification sent by a Client.
+func (n *Notification) Validate() error {
+	payload, err := n.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		// Measure the payload as it will be sent, which is compacted.
+		if payload, err = json.Marshal(n); err != nil {
+			return err
+		}
+	}
+	return n.validate(payload)
+}
+
+func (n *Notification) validate(payload []byte) error {
+	if n.DeviceToken == "" {
+		return &ValidationError{"DeviceToken", "device token is empty", ErrMissingDeviceToken}
+	}
+	if len(n.DeviceToken)%2 != 0 || !isHex(n.DeviceToken) {
+		return &ValidationError{"DeviceToken", "device token must be an even number of hexadecimal digits", ErrBadDeviceToken}
+	}
+	if n.ApnsID != "" && !isUUID(n.ApnsID) {
+		return &ValidationError{"ApnsID", "apns-id must be a canonical UUID", ErrBadMessageID}
+	}
+	if len(n.CollapseID) > MaxCollapseIDSize {
+		return &ValidationError{"CollapseID", fmt.Sprintf("collapse id exceeds %d bytes", MaxCollapseIDSize), ErrBadCollapseID}
+	}
+
+	pushType := n.PushType
+	if pushType == "" {
+		pushType = PushTypeAlert
+	}
+	switch pushType {
+	case PushTypeAlert, PushTypeBackground, PushTypeLocation, PushTypeVOIP, PushTypeComplication,
+		PushTypeFileProvider, PushTypeMDM, PushTypeLiveActivity, PushTypePushToTalk:
+	default:
+		return &ValidationError{"PushType", fmt.Sprintf("unknown push type %q", pushType), ErrInvalidPushType}
+	}
+
+	switch n.Priority {
+	case 0, 1, PriorityLow, PriorityHigh:
+	default:
+		return &ValidationError{"Priority", "priority must be 1, 5 or 10", ErrBadPriority}
+	}
+	if pushType == PushTypeBackground && n.Priority == PriorityHigh {
+		return &ValidationError{"Priority", "background notifications must not use priority 10", ErrBadPriority}
+	}
+
+	if suffix, ok := pushTypeTopicSuffixes[pushType]; ok {
+		if n.Topic == "" {
+			return &ValidationError{"Topic", fmt.Sprintf("%s notifications require a topic", pushType), ErrMissingTopic}
+		}
+		if len(n.Topic) <= len(suffix) || n.Topic[len(n.Topic)-len(suffix):] != suffix {
+			return &ValidationError{"Topic", fmt.Sprintf("%s notifications require a topic ending in %s", pushType, suffix), ErrBadTopic}
+		}
+	}
+
+	if len(payload) == 0 || string(payload) == "null" {
+		return &ValidationError{"Payload", "payload is empty", ErrPayloadEmpty}
+	}
+	maxSize := MaxPayloadSize
+	if pushType == PushTypeVOIP {
+		maxSize = MaxVOIPPayloadSize
+	}
+	if len(payload) > maxSize {
+		return &ValidationError{"Payload", fmt.Sprintf("payload of %d bytes exceeds %d bytes", len(payload), maxSize), ErrPayloadTooLarge}
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHex(string(c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
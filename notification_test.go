@@ -1,6 +1,8 @@
 package apns2_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/sideshow/apns2"
@@ -30,3 +32,90 @@ func TestMarshalJSON(t *testing.T) {
 		assert.Equal(t, scenario.err, err)
 	}
 }
+
+func mockValidNotification() *apns2.Notification {
+	return &apns2.Notification{
+		DeviceToken: "11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7",
+		Topic:       "com.example.app",
+		Payload:     []byte(`{"aps":{"alert":"Hello!"}}`),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, mockValidNotification().Validate())
+
+	scenarios := []struct {
+		name   string
+		modify func(n *apns2.Notification)
+		field  string
+		err    error
+	}{
+		{"missing token", func(n *apns2.Notification) { n.DeviceToken = "" }, "DeviceToken", apns2.ErrMissingDeviceToken},
+		{"non-hex token", func(n *apns2.Notification) { n.DeviceToken = "zz" }, "DeviceToken", apns2.ErrBadDeviceToken},
+		{"odd length token", func(n *apns2.Notification) { n.DeviceToken = "abc" }, "DeviceToken", apns2.ErrBadDeviceToken},
+		{"bad apns-id", func(n *apns2.Notification) { n.ApnsID = "123e4567-e89b-12d3-a456" }, "ApnsID", apns2.ErrBadMessageID},
+		{"long collapse id", func(n *apns2.Notification) { n.CollapseID = strings.Repeat("a", 65) }, "CollapseID", apns2.ErrBadCollapseID},
+		{"unknown push type", func(n *apns2.Notification) { n.PushType = "sms" }, "PushType", apns2.ErrInvalidPushType},
+		{"bad priority", func(n *apns2.Notification) { n.Priority = 7 }, "Priority", apns2.ErrBadPriority},
+		{"background high priority", func(n *apns2.Notification) {
+			n.PushType = apns2.PushTypeBackground
+			n.Priority = apns2.PriorityHigh
+		}, "Priority", apns2.ErrBadPriority},
+		{"voip without suffix", func(n *apns2.Notification) { n.PushType = apns2.PushTypeVOIP }, "Topic", apns2.ErrBadTopic},
+		{"liveactivity without topic", func(n *apns2.Notification) {
+			n.PushType = apns2.PushTypeLiveActivity
+			n.Topic = ""
+		}, "Topic", apns2.ErrMissingTopic},
+		{"nil payload", func(n *apns2.Notification) { n.Payload = nil }, "Payload", apns2.ErrPayloadEmpty},
+		{"empty payload", func(n *apns2.Notification) { n.Payload = "" }, "Payload", apns2.ErrPayloadEmpty},
+		{"large payload", func(n *apns2.Notification) {
+			n.Payload = `{"aps":{"alert":"` + strings.Repeat("a", 4096) + `"}}`
+		}, "Payload", apns2.ErrPayloadTooLarge},
+	}
+
+	for _, s := range scenarios {
+		n := mockValidNotification()
+		s.modify(n)
+		err := n.Validate()
+		var validationErr *apns2.ValidationError
+		if assert.True(t, errors.As(err, &validationErr), s.name) {
+			assert.Equal(t, s.field, validationErr.Field, s.name)
+			assert.True(t, errors.Is(err, s.err), s.name)
+		}
+	}
+}
+
+func TestValidatePushTypeTopics(t *testing.T) {
+	scenarios := map[apns2.EPushType]string{
+		apns2.PushTypeVOIP:         "com.example.app.voip",
+		apns2.PushTypeComplication: "com.example.app.complication",
+		apns2.PushTypeFileProvider: "com.example.app.pushkit.fileprovider",
+		apns2.PushTypeLiveActivity: "com.example.app.push-type.liveactivity",
+		apns2.PushTypePushToTalk:   "com.example.app.voip-ptt",
+		apns2.PushTypeLocation:     "com.example.app.location-query",
+		apns2.PushTypeMDM:          "com.apple.mgmt.External.example",
+	}
+	for pushType, topic := range scenarios {
+		n := mockValidNotification()
+		n.PushType = pushType
+		n.Topic = topic
+		assert.NoError(t, n.Validate(), pushType)
+	}
+}
+
+func TestValidateVOIPPayloadSize(t *testing.T) {
+	n := mockValidNotification()
+	n.PushType = apns2.PushTypeVOIP
+	n.Topic = "com.example.app.voip"
+	n.Payload = `{"aps":{},"data":"` + strings.Repeat("a", 4096) + `"}`
+	assert.NoError(t, n.Validate())
+}
+
+func TestValidationErrorClassification(t *testing.T) {
+	n := mockValidNotification()
+	n.Priority = 3
+	err := n.Validate()
+	assert.EqualError(t, err, "apns2: invalid notification Priority: priority must be 1, 5 or 10")
+	assert.True(t, apns2.IsPayloadProblem(err))
+	assert.True(t, apns2.IsPermanent(err))
+}
@@ -0,0 +1,14 @@
+package apns2
+
+// Pusher sends notifications. It is implemented by Client, Router and
+// Dispatcher, so code which sends notifications can depend on a Pusher and be
+// tested with a fake, such as apns2test.FakePusher.
+type Pusher interface {
+	PushWithContext(ctx Context, n *Notification) (*Response, error)
+}
+
+var (
+	_ Pusher = (*Client)(nil)
+	_ Pusher = (*Router)(nil)
+	_ Pusher = (*Dispatcher)(nil)
+)
@@ -148,6 +148,22 @@ func (c *Response) Sent() bool {
 	return c.StatusCode == StatusSent
 }
 
+// Err returns nil if the notification was successfully sent, or a
+// *ReasonError describing why it was rejected. The error can be compared to
+// the Err sentinel values with errors.Is, or classified with helpers such as
+// ShouldDeleteToken and IsRetryable.
+func (c *Response) Err() error {
+	if c.Sent() {
+		return nil
+	}
+	return &ReasonError{
+		StatusCode: c.StatusCode,
+		Reason:     c.Reason,
+		ApnsID:     c.ApnsID,
+		Timestamp:  c.Timestamp,
+	}
+}
+
 // Time represents a device uninstall time
 type Time struct {
 	time.Time
@@ -0,0 +1,112 @@
+package apns2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryReasons are the Reason values retried by a BackoffPolicy if its
+// Reasons are not set. These are the reasons which indicate a temporary
+// problem with the APNs rather than a problem with the notification.
+var DefaultRetryReasons = map[string]bool{
+	ReasonTooManyRequests:     true,
+	ReasonInternalServerError: true,
+	ReasonServiceUnavailable:  true,
+	ReasonShutdown:            true,
+}
+
+// RetryPolicy decides whether a push should be retried after it was rejected
+// by the APNs or failed to send, and how long to wait before retrying.
+//
+// A Client with a RetryPolicy retries with the same ApnsID, so attempts can be
+// correlated. It stops retrying once the Notification's Expiration has passed
+// or the context is done.
+type RetryPolicy interface {
+	// Retry is called after each unsuccessful attempt, starting at 1, with
+	// the result of the attempt. If the push failed to send, res is nil. If
+	// the response could not be decoded, err is a *ResponseError. It returns
+	// the delay before the next attempt, and false if the push should not be
+	// retried.
+	Retry(attempt int, res *Response, err error) (time.Duration, bool)
+}
+
+// BackoffPolicy is a RetryPolicy which retries transport errors, including
+// GOAWAY frames from the APNs, responses with a retryable Reason, and
+// responses with a 429 or 5xx status whose Reason is empty, unknown or could
+// not be decoded, using exponential backoff with jitter.
+type BackoffPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. It doubles for each
+	// subsequent retry.
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum delay between retries. Set zero for no limit.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction of each delay which is randomized, between 0
+	// and 1. This spreads out the retries of concurrent pushes.
+	Jitter float64
+
+	// Reasons are the APNs Reason values which are retried. If nil,
+	// DefaultRetryReasons is used.
+	Reasons map[string]bool
+}
+
+// NewBackoffPolicy returns a new BackoffPolicy which makes up to 3 attempts,
+// starting with a delay of 100 milliseconds, up to a maximum of 5 seconds,
+// with 20% jitter.
+func NewBackoffPolicy() *BackoffPolicy {
+	return &BackoffPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// Retry implements RetryPolicy.
+func (p *BackoffPolicy) Retry(attempt int, res *Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if err != nil {
+		var respErr *ResponseError
+		if errors.As(err, &respErr) {
+			if !retryableStatus(respErr.StatusCode) {
+				return 0, false
+			}
+		} else if res != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+	} else {
+		reasons := p.Reasons
+		if reasons == nil {
+			reasons = DefaultRetryReasons
+		}
+		if !reasons[res.Reason] {
+			// A missing or unknown reason falls back to the status code.
+			if _, known := reasonClasses[res.Reason]; known || !retryableStatus(res.StatusCode) {
+				return 0, false
+			}
+		}
+	}
+	return p.delay(attempt), true
+}
+
+func (p *BackoffPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay != 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
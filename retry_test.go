@@ -0,0 +1,217 @@
+package apns2_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockRetryPolicy() *apns.BackoffPolicy {
+	return &apns.BackoffPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	}
+}
+
+func mockFailingServer(failures int, status int, reason string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("apns-id"))
+		attempt := len(ids)
+		mu.Unlock()
+		w.Header().Set("apns-id", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01")
+		if attempt <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"reason":"` + reason + `"}`))
+		}
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ids...)
+	}
+}
+
+// Unit Tests
+
+func TestNewBackoffPolicy(t *testing.T) {
+	policy := apns.NewBackoffPolicy()
+	assert.Equal(t, 3, policy.MaxAttempts)
+	assert.Equal(t, 100*time.Millisecond, policy.BaseDelay)
+	assert.Equal(t, 5*time.Second, policy.MaxDelay)
+}
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	policy := &apns.BackoffPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	res := &apns.Response{StatusCode: 503, Reason: apns.ReasonServiceUnavailable}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, retry := policy.Retry(attempt+1, res, nil)
+		assert.True(t, retry)
+		assert.Equal(t, expected, delay)
+	}
+}
+
+func TestBackoffPolicyJitter(t *testing.T) {
+	policy := &apns.BackoffPolicy{MaxAttempts: 2, BaseDelay: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.Retry(1, nil, errors.New("connection reset"))
+		assert.True(t, delay > 500*time.Millisecond && delay <= time.Second)
+	}
+}
+
+func TestBackoffPolicyRetry(t *testing.T) {
+	policy := mockRetryPolicy()
+	scenarios := []struct {
+		attempt int
+		res     *apns.Response
+		err     error
+		retry   bool
+	}{
+		{1, &apns.Response{StatusCode: 429, Reason: apns.ReasonTooManyRequests}, nil, true},
+		{1, &apns.Response{StatusCode: 500, Reason: apns.ReasonInternalServerError}, nil, true},
+		{1, &apns.Response{StatusCode: 503, Reason: apns.ReasonServiceUnavailable}, nil, true},
+		{1, &apns.Response{StatusCode: 503, Reason: apns.ReasonShutdown}, nil, true},
+		{1, &apns.Response{StatusCode: 400, Reason: apns.ReasonBadDeviceToken}, nil, false},
+		{1, &apns.Response{StatusCode: 410, Reason: apns.ReasonUnregistered}, nil, false},
+		{1, &apns.Response{StatusCode: 502}, nil, true},
+		{1, &apns.Response{StatusCode: 503, Reason: "SomethingNew"}, nil, true},
+		{1, &apns.Response{StatusCode: 400}, nil, false},
+		{1, &apns.Response{StatusCode: 400, Reason: "SomethingNew"}, nil, false},
+		{1, nil, errors.New("http2: server sent GOAWAY and closed the connection"), true},
+		{1, nil, context.Canceled, false},
+		{1, nil, context.DeadlineExceeded, false},
+		{1, &apns.Response{}, errors.New("invalid character"), false},
+		{1, &apns.Response{}, &apns.ResponseError{StatusCode: 502, Err: errors.New("invalid character")}, true},
+		{1, &apns.Response{}, &apns.ResponseError{StatusCode: 200, Err: errors.New("invalid character")}, false},
+		{3, &apns.Response{StatusCode: 503, Reason: apns.ReasonServiceUnavailable}, nil, false},
+	}
+	for _, scenario := range scenarios {
+		_, retry := policy.Retry(scenario.attempt, scenario.res, scenario.err)
+		assert.Equal(t, scenario.retry, retry, scenario)
+	}
+}
+
+func TestBackoffPolicyCustomReasons(t *testing.T) {
+	policy := mockRetryPolicy()
+	policy.Reasons = map[string]bool{apns.ReasonIdleTimeout: true}
+	_, retry := policy.Retry(1, &apns.Response{StatusCode: 400, Reason: apns.ReasonIdleTimeout}, nil)
+	assert.True(t, retry)
+	_, retry = policy.Retry(1, &apns.Response{StatusCode: 503, Reason: apns.ReasonServiceUnavailable}, nil)
+	assert.False(t, retry)
+}
+
+// Functional Tests
+
+func TestClientRetriesWithSameApnsID(t *testing.T) {
+	server, requests := mockFailingServer(2, http.StatusServiceUnavailable, apns.ReasonServiceUnavailable)
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, []string{"", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01"}, requests())
+}
+
+func TestClientRetriesUndecodableResponseWithSameApnsID(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("apns-id"))
+		attempt := len(ids)
+		mu.Unlock()
+		w.Header().Set("apns-id", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01")
+		if attempt == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>Bad Gateway</html>"))
+		}
+	}))
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+	assert.Equal(t, []string{"", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01"}, ids)
+}
+
+func TestClientRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server, requests := mockFailingServer(5, http.StatusTooManyRequests, apns.ReasonTooManyRequests)
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, apns.ReasonTooManyRequests, res.Reason)
+	assert.Len(t, requests(), 3)
+}
+
+func TestClientDoesNotRetryPermanentReason(t *testing.T) {
+	server, requests := mockFailingServer(5, http.StatusBadRequest, apns.ReasonBadDeviceToken)
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(mockNotification())
+	assert.NoError(t, err)
+	assert.Equal(t, apns.ReasonBadDeviceToken, res.Reason)
+	assert.Len(t, requests(), 1)
+}
+
+func TestClientDoesNotRetryExpiredNotification(t *testing.T) {
+	server, requests := mockFailingServer(5, http.StatusServiceUnavailable, apns.ReasonServiceUnavailable)
+	defer server.Close()
+
+	n := mockNotification()
+	n.Expiration = time.Now().Add(-time.Minute)
+	client := mockClient(server.URL)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(n)
+	assert.NoError(t, err)
+	assert.Equal(t, apns.ReasonServiceUnavailable, res.Reason)
+	assert.Len(t, requests(), 1)
+}
+
+func TestClientRetryStopsWhenContextDone(t *testing.T) {
+	server, requests := mockFailingServer(5, http.StatusServiceUnavailable, apns.ReasonServiceUnavailable)
+	defer server.Close()
+
+	client := mockClient(server.URL)
+	client.RetryPolicy = &apns.BackoffPolicy{MaxAttempts: 5, BaseDelay: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, err := client.PushWithContext(ctx, mockNotification())
+	assert.Nil(t, res)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Len(t, requests(), 1)
+}
+
+func TestClientRetriesTransportErrors(t *testing.T) {
+	server, requests := mockFailingServer(0, http.StatusOK, "")
+	url := server.URL
+	server.Close()
+
+	client := mockClient(url)
+	client.RetryPolicy = mockRetryPolicy()
+	res, err := client.Push(mockNotification())
+	assert.Error(t, err)
+	assert.Nil(t, res)
+	assert.Len(t, requests(), 0)
+}
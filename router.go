@@ -0,0 +1,137 @@
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sideshow/apns2/certificate"
+)
+
+// ErrUnknownTopic is returned by Router when no Client is registered for a
+// notification's topic.
+var ErrUnknownTopic = errors.New("apns2: no client registered for topic")
+
+// Router sends notifications for many apps, which may use different
+// credentials and environments, by picking the Client registered for each
+// notification's Topic.
+//
+// Clients are registered with a pattern, which is either a topic, such as
+// "com.example.app", or a prefix ending in "*", such as "com.example.*". A
+// topic matches the longest pattern, and "*" matches every topic. The
+// push-type suffix of a topic, such as ".voip" for VoIP notifications, is
+// removed if the full topic is not registered, so "com.example.app" also
+// routes "com.example.app.voip".
+type Router struct {
+	mu       sync.RWMutex
+	topics   map[string]*Client
+	prefixes []routerPrefix
+}
+
+type routerPrefix struct {
+	prefix string
+	client *Client
+}
+
+// NewRouter returns a new Router with no registered clients.
+func NewRouter() *Router {
+	return &Router{topics: map[string]*Client{}}
+}
+
+// Handle registers the client for the pattern, replacing any client already
+// registered for it.
+func (r *Router) Handle(pattern string, client *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.topics == nil {
+		r.topics = map[string]*Client{}
+	}
+	if !strings.HasSuffix(pattern, "*") {
+		r.topics[pattern] = client
+		return
+	}
+	prefix := strings.TrimSuffix(pattern, "*")
+	for i, p := range r.prefixes {
+		if p.prefix == prefix {
+			r.prefixes[i].client = client
+			return
+		}
+	}
+	r.prefixes = append(r.prefixes, routerPrefix{prefix, client})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+}
+
+// HandleCertificate creates a Client for the certificate and registers it for
+// each of the topics in the certificate, as reported by certificate.Inspect.
+// The Client uses the production environment if the certificate is valid for
+// it, and the development environment otherwise. It returns the Client, so
+// that it can be configured further.
+func (r *Router) HandleCertificate(cert tls.Certificate) (*Client, error) {
+	info, err := certificate.Inspect(cert)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Topics) == 0 {
+		return nil, errors.New("apns2: certificate has no topics")
+	}
+	client := NewClient(cert).Development()
+	if info.Production {
+		client.Production()
+	}
+	for _, topic := range info.Topics {
+		r.Handle(topic.Name, client)
+	}
+	return client, nil
+}
+
+// Client returns the Client registered for the notification's Topic. It
+// returns an error wrapping ErrUnknownTopic if there is none.
+func (r *Router) Client(n *Notification) (*Client, error) {
+	topics := []string{n.Topic}
+	pushType := n.PushType
+	if pushType == "" {
+		pushType = PushTypeAlert
+	}
+	if suffix, ok := pushTypeTopicSuffixes[pushType]; ok && strings.HasSuffix(n.Topic, suffix) {
+		topics = append(topics, strings.TrimSuffix(n.Topic, suffix))
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, topic := range topics {
+		if client, ok := r.topics[topic]; ok {
+			return client, nil
+		}
+	}
+	for _, p := range r.prefixes {
+		for _, topic := range topics {
+			if strings.HasPrefix(topic, p.prefix) {
+				return p.client, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownTopic, n.Topic)
+}
+
+// Push sends a Notification with the Client registered for its Topic, like
+// Client.Push.
+func (r *Router) Push(n *Notification) (*Response, error) {
+	return r.PushWithContext(context.Background(), n)
+}
+
+// PushWithContext sends a Notification with the Client registered for its
+// Topic, like Client.PushWithContext. It returns an error wrapping
+// ErrUnknownTopic if no Client is registered for the Topic.
+func (r *Router) PushWithContext(ctx Context, n *Notification) (*Response, error) {
+	client, err := r.Client(n)
+	if err != nil {
+		return nil, err
+	}
+	return client.PushWithContext(ctx, n)
+}
@@ -0,0 +1,149 @@
+package apns2_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apns "github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockTopicCertificate(t *testing.T, production bool, topics ...string) tls.Certificate {
+	var values []asn1.RawValue
+	for _, topic := range topics {
+		values = append(values, asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(topic)})
+	}
+	der, _ := asn1.Marshal(values)
+	extensions := []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 6}, Value: der},
+		{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 1}, Value: []byte{5, 0}},
+	}
+	if production {
+		extensions = append(extensions, pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 2}, Value: []byte{5, 0}})
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: extensions,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{cert}, PrivateKey: key}
+}
+
+func mockRouteNotification(topic string, pushType apns.EPushType) *apns.Notification {
+	n := mockNotification()
+	n.Topic = topic
+	n.PushType = pushType
+	return n
+}
+
+// Unit Tests
+
+func TestRouterClient(t *testing.T) {
+	app, voip, team, fallback := mockClient("app"), mockClient("voip"), mockClient("team"), mockClient("fallback")
+	router := apns.NewRouter()
+	router.Handle("com.example.app", app)
+	router.Handle("com.example.app.voip", voip)
+	router.Handle("com.example.*", team)
+
+	scenarios := []struct {
+		topic    string
+		pushType apns.EPushType
+		client   *apns.Client
+	}{
+		{"com.example.app", "", app},
+		{"com.example.app", apns.PushTypeBackground, app},
+		{"com.example.app.voip", apns.PushTypeVOIP, voip},
+		{"com.example.app.complication", apns.PushTypeComplication, app},
+		{"com.example.app.push-type.liveactivity", apns.PushTypeLiveActivity, app},
+		{"com.example.other", "", team},
+		{"com.example.other.voip", apns.PushTypeVOIP, team},
+	}
+	for _, s := range scenarios {
+		client, err := router.Client(mockRouteNotification(s.topic, s.pushType))
+		assert.NoError(t, err, s.topic)
+		assert.Same(t, s.client, client, s.topic)
+	}
+
+	_, err := router.Client(mockRouteNotification("com.other.app", ""))
+	assert.True(t, errors.Is(err, apns.ErrUnknownTopic))
+	assert.EqualError(t, err, `apns2: no client registered for topic "com.other.app"`)
+
+	router.Handle("*", fallback)
+	client, err := router.Client(mockRouteNotification("com.other.app", ""))
+	assert.NoError(t, err)
+	assert.Same(t, fallback, client)
+}
+
+func TestRouterLongestPrefix(t *testing.T) {
+	short, long, replaced := mockClient("short"), mockClient("long"), mockClient("replaced")
+	router := apns.NewRouter()
+	router.Handle("com.*", short)
+	router.Handle("com.example.*", long)
+	client, _ := router.Client(mockRouteNotification("com.example.app", ""))
+	assert.Same(t, long, client)
+	client, _ = router.Client(mockRouteNotification("com.other.app", ""))
+	assert.Same(t, short, client)
+
+	router.Handle("com.example.*", replaced)
+	client, _ = router.Client(mockRouteNotification("com.example.app", ""))
+	assert.Same(t, replaced, client)
+}
+
+func TestRouterHandleCertificate(t *testing.T) {
+	router := apns.NewRouter()
+	client, err := router.HandleCertificate(mockTopicCertificate(t, true, "com.example.app", "com.example.app.voip"))
+	assert.NoError(t, err)
+	assert.Equal(t, apns.HostProduction, client.Host)
+	for _, topic := range []string{"com.example.app", "com.example.app.voip"} {
+		routed, err := router.Client(mockRouteNotification(topic, ""))
+		assert.NoError(t, err)
+		assert.Same(t, client, routed)
+	}
+
+	client, err = router.HandleCertificate(mockTopicCertificate(t, false, "com.example.dev"))
+	assert.NoError(t, err)
+	assert.Equal(t, apns.HostDevelopment, client.Host)
+}
+
+func TestRouterHandleCertificateWithoutTopics(t *testing.T) {
+	_, err := apns.NewRouter().HandleCertificate(tls.Certificate{})
+	assert.Error(t, err)
+}
+
+// Functional Tests
+
+func TestRouterPush(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "com.example.app", r.Header.Get("apns-topic"))
+		w.Header().Set("apns-id", "C0F9F8B2-5A4E-4E0C-8F2B-5B3B1C8F0A01")
+	}))
+	defer server.Close()
+
+	router := apns.NewRouter()
+	router.Handle("com.example.app", mockClient(server.URL))
+	res, err := router.Push(mockRouteNotification("com.example.app", ""))
+	assert.NoError(t, err)
+	assert.True(t, res.Sent())
+
+	res, err = router.PushWithContext(context.Background(), mockRouteNotification("com.other.app", ""))
+	assert.Nil(t, res)
+	assert.True(t, errors.Is(err, apns.ErrUnknownTopic))
+}
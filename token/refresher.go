@@ -0,0 +1,270 @@
+package token
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The reasons passed to Rejected, which are the APNs reason strings of
+// apns2.ReasonExpiredProviderToken and apns2.ReasonInvalidProviderToken. The
+// apns2 package imports this one, so they are repeated here unexported.
+const (
+	reasonExpiredProviderToken = "ExpiredProviderToken"
+	reasonInvalidProviderToken = "InvalidProviderToken"
+)
+
+var (
+	// DefaultRefreshInterval is the default interval at which a Refresher
+	// generates a new token. It is less than TokenTimeout, so that the
+	// bearer is renewed in the background before it expires.
+	DefaultRefreshInterval = 45 * time.Minute
+
+	// MinRefreshInterval is the default minimum interval between tokens
+	// generated by a Refresher. The APNs returns TooManyProviderTokenUpdates
+	// if tokens are updated more often than once every 20 minutes.
+	MinRefreshInterval = 20 * time.Minute
+
+	// RefreshRetryInterval is the interval at which a Refresher retries after
+	// failing to generate a token in the background.
+	RefreshRetryInterval = time.Minute
+)
+
+// Provider provides bearer tokens to a Client using token-based
+// authentication.
+type Provider interface {
+	// Bearer returns the current bearer token.
+	Bearer() (string, error)
+
+	// Rejected is called when the APNs rejects bearer. The reason is passed
+	// through unchanged from the APNs response, and is either
+	// apns2.ReasonExpiredProviderToken or apns2.ReasonInvalidProviderToken.
+	// It returns true if a different bearer is now available and the push
+	// should be retried.
+	Rejected(bearer, reason string) bool
+}
+
+// KeyedProvider is a Provider which has a key identifying it. A ClientManager
+// uses it to key Clients with a TokenProvider.
+type KeyedProvider interface {
+	Provider
+
+	// Key returns the Team ID and Key ID identifying the provider. It must not
+	// change while the provider is used, even if the provider signs bearers
+	// with another key, so that the Client can be found again by its key.
+	Key() (teamID, keyID string)
+}
+
+// Refresher is a Provider which generates new bearer tokens for a Token in
+// the background, before they expire. Bearer returns the current bearer
+// without taking the Token's lock, so pushes do not wait while a token is
+// signed.
+//
+// When the APNs rejects a bearer, Rejected forces a refresh, unless the
+// bearer was generated less than MinInterval ago. This avoids
+// TooManyProviderTokenUpdates errors.
+//
+// The first call to Bearer generates a token and starts the background
+// refresh. Call Stop to stop it. The age of bearers is told by the Token's
+// Clock, but the background refresh waits on the system clock.
+type Refresher struct {
+	// Token is the token which is refreshed.
+	Token *Token
+
+	// Interval is the interval at which a new token is generated. If zero,
+	// DefaultRefreshInterval is used. An Interval less than MinInterval is
+	// treated as MinInterval.
+	Interval time.Duration
+
+	// MinInterval is the minimum interval between tokens when a refresh is
+	// forced by Rejected. If zero, MinRefreshInterval is used.
+	MinInterval time.Duration
+
+	// OnError, if set, is called when a token fails to generate. It is called
+	// without the Refresher's lock held, so it may call its methods.
+	OnError func(err error)
+
+	mu       sync.Mutex
+	state    atomic.Value
+	once     sync.Once
+	stopMu   sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type refreshState struct {
+	bearer   string
+	issuedAt time.Time
+	err      error
+}
+
+// NewRefresher returns a new Refresher for the token, with the default
+// Interval and MinInterval.
+func NewRefresher(t *Token) *Refresher {
+	return &Refresher{
+		Token:       t,
+		Interval:    DefaultRefreshInterval,
+		MinInterval: MinRefreshInterval,
+	}
+}
+
+// Bearer returns the current bearer token. If the current bearer has expired
+// because it could not be refreshed in the background, it tries to generate a
+// new one and returns a *GenerateError if that fails.
+func (r *Refresher) Bearer() (string, error) {
+	var startErr error
+	r.once.Do(func() {
+		startErr = r.start()
+	})
+	r.notify(startErr)
+	s := r.load()
+	if r.Token.now().Sub(s.issuedAt) < TokenTimeout*time.Second {
+		return s.bearer, nil
+	}
+
+	var err error
+	r.mu.Lock()
+	s = r.load()
+	if r.Token.now().Sub(s.issuedAt) >= TokenTimeout*time.Second {
+		s, err = r.refreshLocked()
+	}
+	r.mu.Unlock()
+	r.notify(err)
+	if s.err != nil && r.Token.now().Sub(s.issuedAt) >= TokenTimeout*time.Second {
+		return "", s.err
+	}
+	return s.bearer, nil
+}
+
+// Rejected implements Provider. It forces a refresh if bearer is the current
+// bearer and was generated at least MinInterval ago.
+func (r *Refresher) Rejected(bearer, reason string) bool {
+	if reason != reasonExpiredProviderToken && reason != reasonInvalidProviderToken {
+		return false
+	}
+	r.mu.Lock()
+	s := r.load()
+	if s.bearer != bearer {
+		r.mu.Unlock()
+		return s.bearer != ""
+	}
+	if r.Token.now().Sub(s.issuedAt) < r.minInterval() {
+		r.mu.Unlock()
+		return false
+	}
+	s, err := r.refreshLocked()
+	r.mu.Unlock()
+	r.notify(err)
+	return err == nil && s.bearer != bearer
+}
+
+// Key implements KeyedProvider. It returns the Team ID and Key ID of the
+// Token.
+func (r *Refresher) Key() (teamID, keyID string) {
+	return r.Token.TeamID, r.Token.KeyID
+}
+
+// Stop stops refreshing the token in the background. Bearer continues to
+// work, but generates tokens on demand once the current bearer expires.
+func (r *Refresher) Stop() {
+	stop := r.stopChan()
+	r.stopOnce.Do(func() {
+		close(stop)
+	})
+}
+
+// start generates the first token and starts the background refresh. It
+// returns any error generating the token, for the caller to pass to notify
+// outside of once.Do.
+func (r *Refresher) start() error {
+	r.mu.Lock()
+	_, err := r.refreshLocked()
+	r.mu.Unlock()
+	go r.run()
+	return err
+}
+
+func (r *Refresher) run() {
+	stop := r.stopChan()
+	for {
+		interval := r.interval()
+		wait := r.load().issuedAt.Add(interval).Sub(r.Token.now())
+		if wait <= 0 {
+			wait = RefreshRetryInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		var err error
+		r.mu.Lock()
+		if r.Token.now().Sub(r.load().issuedAt) >= interval {
+			_, err = r.refreshLocked()
+		}
+		r.mu.Unlock()
+		r.notify(err)
+	}
+}
+
+// stopChan returns the channel closed by Stop, creating it if needed, so that
+// a Refresher which was not created with NewRefresher can be stopped.
+func (r *Refresher) stopChan() chan struct{} {
+	r.stopMu.Lock()
+	defer r.stopMu.Unlock()
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+	}
+	return r.stop
+}
+
+func (r *Refresher) interval() time.Duration {
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultRefreshInterval
+	}
+	if min := r.minInterval(); interval < min {
+		interval = min
+	}
+	return interval
+}
+
+func (r *Refresher) minInterval() time.Duration {
+	if r.MinInterval == 0 {
+		return MinRefreshInterval
+	}
+	return r.MinInterval
+}
+
+func (r *Refresher) load() refreshState {
+	s, _ := r.state.Load().(refreshState)
+	return s
+}
+
+// notify calls OnError with an error from refreshLocked. It is called without
+// r.mu held, so the callback can use the Refresher.
+func (r *Refresher) notify(err error) {
+	if err != nil && r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// refreshLocked generates a new token. If that fails, the previous bearer is
+// kept and the error is returned as well as stored in the state. r.mu must be
+// held.
+func (r *Refresher) refreshLocked() (refreshState, error) {
+	t := r.Token
+	now := t.now()
+	t.Lock()
+	_, err := t.Generate()
+	s := refreshState{bearer: t.Bearer, issuedAt: now}
+	t.Unlock()
+	if err != nil {
+		s = r.load()
+		s.err = &GenerateError{KeyID: t.KeyID, TeamID: t.TeamID, Err: err}
+	}
+	r.state.Store(s)
+	return s, s.err
+}
@@ -0,0 +1,194 @@
+package token_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/apns2test"
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockRefresher(t *testing.T) *token.Refresher {
+	authKey, err := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
+	assert.NoError(t, err)
+	r := token.NewRefresher(&token.Token{AuthKey: authKey, KeyID: "ABC123DEFG", TeamID: "DEF123GHIJ"})
+	t.Cleanup(r.Stop)
+	return r
+}
+
+// Unit Tests
+
+func TestNewRefresher(t *testing.T) {
+	r := token.NewRefresher(&token.Token{})
+	assert.Equal(t, token.DefaultRefreshInterval, r.Interval)
+	assert.Equal(t, token.MinRefreshInterval, r.MinInterval)
+}
+
+func TestRefresherZeroValue(t *testing.T) {
+	authKey, err := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
+	assert.NoError(t, err)
+	r := &token.Refresher{Token: &token.Token{AuthKey: authKey, KeyID: "ABC123DEFG", TeamID: "DEF123GHIJ"}}
+	bearer, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, bearer)
+
+	// A zero MinInterval rate limits forced refreshes by default.
+	assert.False(t, r.Rejected(bearer, apns2.ReasonExpiredProviderToken))
+	r.Stop()
+	r.Stop()
+}
+
+func TestRefresherStopWithoutBearer(t *testing.T) {
+	r := &token.Refresher{}
+	assert.NotPanics(t, r.Stop)
+}
+
+func TestRefresherBearer(t *testing.T) {
+	r := mockRefresher(t)
+	bearer, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, bearer)
+	assert.Equal(t, r.Token.Bearer, bearer)
+
+	again, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.Equal(t, bearer, again)
+}
+
+func TestRefresherBearerError(t *testing.T) {
+	r := token.NewRefresher(&token.Token{KeyID: "ABC123DEFG"})
+	defer r.Stop()
+	var errs []error
+	r.OnError = func(err error) { errs = append(errs, err) }
+
+	bearer, err := r.Bearer()
+	assert.Equal(t, "", bearer)
+	var generateErr *token.GenerateError
+	assert.True(t, errors.As(err, &generateErr))
+	assert.True(t, errors.Is(err, token.ErrAuthKeyNil))
+	assert.Len(t, errs, 2)
+}
+
+func TestRefresherOnErrorCanUseRefresher(t *testing.T) {
+	r := token.NewRefresher(&token.Token{KeyID: "ABC123DEFG"})
+	defer r.Stop()
+	calls := 0
+	r.OnError = func(err error) {
+		calls++
+		if calls == 1 {
+			r.Bearer()
+			r.Rejected("", apns2.ReasonExpiredProviderToken)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Bearer()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnError deadlocked the Refresher")
+	}
+	assert.Equal(t, 4, calls)
+}
+
+func TestRefresherRejectedIsRateLimited(t *testing.T) {
+	r := mockRefresher(t)
+	bearer, _ := r.Bearer()
+	assert.False(t, r.Rejected(bearer, apns2.ReasonExpiredProviderToken))
+	current, _ := r.Bearer()
+	assert.Equal(t, bearer, current)
+}
+
+func TestRefresherRejectedForcesRefresh(t *testing.T) {
+	r := mockRefresher(t)
+	r.MinInterval = time.Nanosecond
+	bearer, _ := r.Bearer()
+
+	assert.True(t, r.Rejected(bearer, apns2.ReasonExpiredProviderToken))
+	refreshed, _ := r.Bearer()
+	assert.NotEqual(t, bearer, refreshed)
+
+	// A stale bearer is retried with the current one, without refreshing.
+	assert.True(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	current, _ := r.Bearer()
+	assert.Equal(t, refreshed, current)
+}
+
+func TestRefresherRejectedOtherReason(t *testing.T) {
+	r := mockRefresher(t)
+	r.MinInterval = time.Nanosecond
+	bearer, _ := r.Bearer()
+	assert.False(t, r.Rejected(bearer, "BadDeviceToken"))
+}
+
+// Functional Tests
+
+func TestRefresherRefreshesInBackground(t *testing.T) {
+	r := mockRefresher(t)
+	r.Interval = 10 * time.Millisecond
+	r.MinInterval = time.Millisecond
+	bearer, _ := r.Bearer()
+	assert.Eventually(t, func() bool {
+		current, _ := r.Bearer()
+		return current != bearer
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRefresherIntervalIsAtLeastMinInterval(t *testing.T) {
+	clock := apns2test.NewFakeClock(time.Unix(1600000000, 0))
+	r := mockRefresher(t)
+	r.Token.Clock = clock
+	r.Interval = time.Millisecond
+	r.MinInterval = time.Hour
+	bearer, _ := r.Bearer()
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	current, _ := r.Bearer()
+	assert.Equal(t, bearer, current)
+}
+
+func TestRefresherConcurrentBearer(t *testing.T) {
+	r := mockRefresher(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bearer, err := r.Bearer()
+			assert.NoError(t, err)
+			assert.NotEmpty(t, bearer)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRefresherWithClock(t *testing.T) {
+	clock := apns2test.NewFakeClock(time.Unix(1600000000, 0))
+	r := mockRefresher(t)
+	r.Token.Clock = clock
+	first, err := r.Bearer()
+	assert.NoError(t, err)
+
+	clock.Advance(token.MinRefreshInterval - time.Second)
+	assert.False(t, r.Rejected(first, apns2.ReasonExpiredProviderToken))
+	clock.Advance(time.Second)
+	assert.True(t, r.Rejected(first, apns2.ReasonExpiredProviderToken))
+	second, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	clock.Advance(token.TokenTimeout * time.Second)
+	third, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.NotEqual(t, second, third)
+}
@@ -0,0 +1,94 @@
+package token
+
+import "sync"
+
+// DefaultRegistry is the Registry used by Share.
+var DefaultRegistry = NewRegistry()
+
+// Registry shares Tokens between Clients which use the same Team ID and Key
+// ID, so that they all send the same bearer. The APNs rejects tokens which are
+// updated too often with TooManyProviderTokenUpdates, which can happen when
+// many Clients each generate their own token for the same key.
+//
+// A shared Token is only regenerated once it has expired, so it is
+// regenerated at most once every TokenTimeout however many Clients use it.
+// Clients using a token.Provider can share a Refresher in the same way.
+type Registry struct {
+	mu         sync.Mutex
+	tokens     map[registryKey]*Token
+	refreshers map[registryKey]*Refresher
+}
+
+type registryKey struct {
+	teamID string
+	keyID  string
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		tokens:     map[registryKey]*Token{},
+		refreshers: map[registryKey]*Refresher{},
+	}
+}
+
+// Share returns the registered Token with the same TeamID and KeyID as t.
+// If there is none, t is registered and returned.
+func (r *Registry) Share(t *Token) *Token {
+	key := registryKey{t.TeamID, t.KeyID}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if shared, ok := r.tokens[key]; ok {
+		return shared
+	}
+	r.tokens[key] = t
+	return t
+}
+
+// Refresher returns the registered Refresher for the shared Token with the same
+// TeamID and KeyID as t. If there is none, a new Refresher is registered and
+// returned.
+func (r *Registry) Refresher(t *Token) *Refresher {
+	key := registryKey{t.TeamID, t.KeyID}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if refresher, ok := r.refreshers[key]; ok {
+		return refresher
+	}
+	shared, ok := r.tokens[key]
+	if !ok {
+		shared = t
+		r.tokens[key] = t
+	}
+	refresher := NewRefresher(shared)
+	r.refreshers[key] = refresher
+	return refresher
+}
+
+// Lookup returns the registered Token for the Team ID and Key ID, or nil if
+// there is none.
+func (r *Registry) Lookup(teamID, keyID string) *Token {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[registryKey{teamID, keyID}]
+}
+
+// Remove removes the registered Token for the Team ID and Key ID, such as when
+// a key is revoked, and stops its Refresher. Clients already using the Token
+// continue to use it.
+func (r *Registry) Remove(teamID, keyID string) {
+	key := registryKey{teamID, keyID}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if refresher, ok := r.refreshers[key]; ok {
+		refresher.Stop()
+		delete(r.refreshers, key)
+	}
+	delete(r.tokens, key)
+}
+
+// Share returns the Token in the DefaultRegistry with the same TeamID and
+// KeyID as t, registering t if there is none.
+func Share(t *Token) *Token {
+	return DefaultRegistry.Share(t)
+}
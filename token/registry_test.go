@@ -0,0 +1,73 @@
+package token_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryShare(t *testing.T) {
+	r := token.NewRegistry()
+	first := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	second := &token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}
+	other := &token.Token{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG"}
+
+	assert.Same(t, first, r.Share(first))
+	assert.Same(t, first, r.Share(second))
+	assert.Same(t, other, r.Share(other))
+	assert.Same(t, first, r.Lookup("DEF123GHIJ", "ABC123DEFG"))
+
+	r.Remove("DEF123GHIJ", "ABC123DEFG")
+	assert.Nil(t, r.Lookup("DEF123GHIJ", "ABC123DEFG"))
+	assert.Same(t, second, r.Share(second))
+}
+
+func TestRegistryRefresher(t *testing.T) {
+	r := token.NewRegistry()
+	tok := r.Share(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"})
+	refresher := r.Refresher(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"})
+	assert.Same(t, tok, refresher.Token)
+	assert.Same(t, refresher, r.Refresher(&token.Token{TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"}))
+
+	other := &token.Token{TeamID: "DEF123GHIJ", KeyID: "XYZ123DEFG"}
+	removed := r.Refresher(other)
+	assert.Same(t, other, removed.Token)
+	assert.Same(t, other, r.Lookup("DEF123GHIJ", "XYZ123DEFG"))
+	r.Remove("DEF123GHIJ", "XYZ123DEFG")
+	assert.NotSame(t, removed, r.Refresher(other))
+}
+
+func TestDefaultRegistryShare(t *testing.T) {
+	tok := &token.Token{TeamID: "TESTSHARE1", KeyID: "TESTSHARE1"}
+	defer token.DefaultRegistry.Remove("TESTSHARE1", "TESTSHARE1")
+	assert.Same(t, tok, token.Share(tok))
+	assert.Same(t, tok, token.Share(&token.Token{TeamID: "TESTSHARE1", KeyID: "TESTSHARE1"}))
+}
+
+func TestRegistrySharedTokenGeneratesOnce(t *testing.T) {
+	authKey, _ := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
+	r := token.NewRegistry()
+	var wg sync.WaitGroup
+	bearers := make([]string, 20)
+	for i := range bearers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shared := r.Share(&token.Token{AuthKey: authKey, TeamID: "DEF123GHIJ", KeyID: "ABC123DEFG"})
+			bearers[i] = shared.GenerateIfExpired()
+		}(i)
+	}
+	wg.Wait()
+	for _, bearer := range bearers {
+		assert.Equal(t, bearers[0], bearer)
+	}
+}
+
+func TestAge(t *testing.T) {
+	assert.Equal(t, time.Duration(0), (&token.Token{}).Age())
+	tok := &token.Token{IssuedAt: time.Now().Add(-10 * time.Minute).Unix()}
+	assert.InDelta(t, float64(10*time.Minute), float64(tok.Age()), float64(2*time.Second))
+}
@@ -0,0 +1,146 @@
+package token
+
+import "sync"
+
+// DefaultFallbackAfter is the default number of InvalidProviderToken
+// rejections after which a Rotator falls back to its secondary key.
+var DefaultFallbackAfter = 3
+
+// Rotator is a Provider which holds a primary and a secondary signing key, to
+// allow keys to be rotated without an outage. Bearers are generated with the
+// primary key until the APNs rejects them as InvalidProviderToken
+// FallbackAfter times, after which the Rotator falls back to the secondary
+// key.
+//
+// Use Promote to make the secondary key the primary, once the APNs accepts
+// it, or SetKeys to replace both keys.
+type Rotator struct {
+	// FallbackAfter is the number of times a bearer generated with the
+	// primary key can be rejected as InvalidProviderToken before falling back
+	// to the secondary key.
+	FallbackAfter int
+
+	// OnFallback, if set, is called when the Rotator falls back from the
+	// primary key to the secondary key.
+	OnFallback func(from, to *Token)
+
+	mu             sync.Mutex
+	teamID         string
+	keyID          string
+	primary        *Token
+	secondary      *Token
+	active         *Token
+	rejectedBearer string
+	rejections     int
+}
+
+// NewRotator returns a new Rotator which uses the primary token until it falls
+// back to the secondary token. The secondary token can be nil.
+func NewRotator(primary, secondary *Token) *Rotator {
+	r := &Rotator{
+		FallbackAfter: DefaultFallbackAfter,
+		primary:       primary,
+		secondary:     secondary,
+		active:        primary,
+	}
+	if primary != nil {
+		r.teamID, r.keyID = primary.TeamID, primary.KeyID
+	}
+	return r
+}
+
+// Active returns the token currently used to generate bearers.
+func (r *Rotator) Active() *Token {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active
+}
+
+// Key implements KeyedProvider. It returns the Team ID and Key ID of the
+// primary token the Rotator was created with, which do not change when it
+// falls back, or on Promote or SetKeys.
+func (r *Rotator) Key() (teamID, keyID string) {
+	return r.teamID, r.keyID
+}
+
+// Bearer implements Provider. It returns the bearer of the active token,
+// generating a new one if it has expired.
+func (r *Rotator) Bearer() (string, error) {
+	return r.Active().GenerateIfExpiredWithError()
+}
+
+// Rejected implements Provider. If bearer was generated with the primary key
+// and has been rejected as InvalidProviderToken FallbackAfter times, the
+// Rotator falls back to the secondary key. It returns true if the active
+// token now has a different bearer.
+func (r *Rotator) Rejected(bearer, reason string) bool {
+	if reason != reasonExpiredProviderToken && reason != reasonInvalidProviderToken {
+		return false
+	}
+
+	r.mu.Lock()
+	active := r.active
+	current := bearerOf(active)
+	if current != bearer {
+		r.mu.Unlock()
+		return current != ""
+	}
+	if reason != reasonInvalidProviderToken || active != r.primary || r.secondary == nil {
+		r.mu.Unlock()
+		return false
+	}
+	if bearer != r.rejectedBearer {
+		r.rejectedBearer = bearer
+		r.rejections = 0
+	}
+	r.rejections++
+	if r.rejections < r.FallbackAfter {
+		r.mu.Unlock()
+		return false
+	}
+	from, to := r.primary, r.secondary
+	r.active = to
+	r.resetLocked()
+	r.mu.Unlock()
+
+	if r.OnFallback != nil {
+		r.OnFallback(from, to)
+	}
+	next, err := to.GenerateIfExpiredWithError()
+	return err == nil && next != bearer
+}
+
+// Promote makes the secondary key the primary key, and the primary key the
+// secondary key. The new primary key is used for new bearers. It does nothing
+// if there is no secondary key.
+func (r *Rotator) Promote() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.secondary == nil {
+		return
+	}
+	r.primary, r.secondary = r.secondary, r.primary
+	r.active = r.primary
+	r.resetLocked()
+}
+
+// SetKeys replaces the primary and secondary keys. The primary key is used
+// for new bearers. The secondary token can be nil.
+func (r *Rotator) SetKeys(primary, secondary *Token) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.primary, r.secondary = primary, secondary
+	r.active = primary
+	r.resetLocked()
+}
+
+func (r *Rotator) resetLocked() {
+	r.rejectedBearer = ""
+	r.rejections = 0
+}
+
+func bearerOf(t *Token) string {
+	t.Lock()
+	defer t.Unlock()
+	return t.Bearer
+}
@@ -0,0 +1,99 @@
+package token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocks
+
+func mockRotatorToken(keyID string) *token.Token {
+	authKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return &token.Token{AuthKey: authKey, KeyID: keyID, TeamID: "DEF123GHIJ"}
+}
+
+// Unit Tests
+
+func TestRotatorBearer(t *testing.T) {
+	primary := mockRotatorToken("PRIMARY")
+	r := token.NewRotator(primary, mockRotatorToken("SECONDARY"))
+	assert.Equal(t, token.DefaultFallbackAfter, r.FallbackAfter)
+	bearer, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.Equal(t, primary.Bearer, bearer)
+	assert.Same(t, primary, r.Active())
+}
+
+func TestRotatorFallback(t *testing.T) {
+	primary, secondary := mockRotatorToken("PRIMARY"), mockRotatorToken("SECONDARY")
+	r := token.NewRotator(primary, secondary)
+	var fallbacks [][2]*token.Token
+	r.OnFallback = func(from, to *token.Token) {
+		fallbacks = append(fallbacks, [2]*token.Token{from, to})
+	}
+
+	bearer, _ := r.Bearer()
+	assert.False(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	assert.False(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	assert.Same(t, primary, r.Active())
+	assert.True(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	assert.Same(t, secondary, r.Active())
+	assert.Equal(t, [][2]*token.Token{{primary, secondary}}, fallbacks)
+
+	next, err := r.Bearer()
+	assert.NoError(t, err)
+	assert.Equal(t, secondary.Bearer, next)
+
+	// Pushes still in flight with the old bearer are retried with the new one.
+	assert.True(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+
+	// The secondary key has no fallback.
+	for i := 0; i < 5; i++ {
+		assert.False(t, r.Rejected(next, apns2.ReasonInvalidProviderToken))
+	}
+	assert.Len(t, fallbacks, 1)
+}
+
+func TestRotatorWithoutSecondary(t *testing.T) {
+	r := token.NewRotator(mockRotatorToken("PRIMARY"), nil)
+	r.FallbackAfter = 1
+	bearer, _ := r.Bearer()
+	assert.False(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	r.Promote()
+	assert.Equal(t, "PRIMARY", r.Active().KeyID)
+}
+
+func TestRotatorIgnoresOtherReasons(t *testing.T) {
+	r := token.NewRotator(mockRotatorToken("PRIMARY"), mockRotatorToken("SECONDARY"))
+	r.FallbackAfter = 1
+	bearer, _ := r.Bearer()
+	assert.False(t, r.Rejected(bearer, apns2.ReasonExpiredProviderToken))
+	assert.False(t, r.Rejected(bearer, "BadDeviceToken"))
+	assert.Equal(t, "PRIMARY", r.Active().KeyID)
+}
+
+func TestRotatorPromote(t *testing.T) {
+	primary, secondary := mockRotatorToken("PRIMARY"), mockRotatorToken("SECONDARY")
+	r := token.NewRotator(primary, secondary)
+	r.Promote()
+	assert.Same(t, secondary, r.Active())
+	bearer, _ := r.Bearer()
+	assert.Equal(t, secondary.Bearer, bearer)
+
+	r.FallbackAfter = 1
+	assert.True(t, r.Rejected(bearer, apns2.ReasonInvalidProviderToken))
+	assert.Same(t, primary, r.Active())
+}
+
+func TestRotatorSetKeys(t *testing.T) {
+	r := token.NewRotator(mockRotatorToken("PRIMARY"), nil)
+	next := mockRotatorToken("NEXT")
+	r.SetKeys(next, nil)
+	assert.Same(t, next, r.Active())
+}
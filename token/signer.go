@@ -0,0 +1,50 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// p256KeySize is the size in bytes of each of the r and s values of an ES256
+// signature.
+const p256KeySize = 32
+
+// signedString signs the token with signer and returns the complete token. The
+// signer returns an ASN.1 DER encoded signature, which is converted to the
+// r||s format required by JWS.
+func signedString(jwtToken *jwt.Token, signer crypto.Signer) (string, error) {
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return "", ErrSignerNotP256
+	}
+	signingString, err := jwtToken.SigningString()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil {
+		return "", err
+	} else if len(rest) > 0 || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 ||
+		sig.R.BitLen() > 8*p256KeySize || sig.S.BitLen() > 8*p256KeySize {
+		return "", ErrInvalidSignature
+	}
+	out := make([]byte, 2*p256KeySize)
+	sig.R.FillBytes(out[:p256KeySize])
+	sig.S.FillBytes(out[p256KeySize:])
+	return strings.Join([]string{signingString, jwt.EncodeSegment(out)}, "."), nil
+}
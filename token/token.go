@@ -1,15 +1,18 @@
 package token
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/sideshow/apns2/clock"
 )
 
 const (
@@ -20,21 +23,52 @@ const (
 	TokenTimeout = 3000
 )
 
-// Possible errors when parsing a .p8 file.
+// Possible errors when parsing a .p8 file or signing a token.
 var (
-	ErrAuthKeyNotPem   = errors.New("token: AuthKey must be a valid .p8 PEM file")
-	ErrAuthKeyNotECDSA = errors.New("token: AuthKey must be of type ecdsa.PrivateKey")
-	ErrAuthKeyNil      = errors.New("token: AuthKey was nil")
+	ErrAuthKeyNotPem    = errors.New("token: AuthKey must be a valid .p8 PEM file")
+	ErrAuthKeyNotECDSA  = errors.New("token: AuthKey must be of type ecdsa.PrivateKey")
+	ErrAuthKeyNil       = errors.New("token: AuthKey was nil")
+	ErrSignerNotP256    = errors.New("token: Signer must have an ecdsa.PublicKey on the P-256 curve")
+	ErrInvalidSignature = errors.New("token: Signer returned an invalid ECDSA signature")
 )
 
 // Token represents an Apple Provider Authentication Token (JSON Web Token).
 type Token struct {
 	sync.Mutex
-	AuthKey  *ecdsa.PrivateKey
+	AuthKey *ecdsa.PrivateKey
+
+	// Signer, if set, is used to sign tokens instead of AuthKey. Use it when
+	// the private key is held in a KMS or HSM which only exposes a signing
+	// operation. Its public key must be an ECDSA P-256 key.
+	Signer crypto.Signer
+
 	KeyID    string
 	TeamID   string
 	IssuedAt int64
 	Bearer   string
+
+	// Clock, if set, is used to tell the time tokens are issued and expire,
+	// instead of the system clock.
+	Clock clock.Clock
+}
+
+// GenerateError is returned by GenerateIfExpiredWithError when a new token
+// could not be generated, such as when the AuthKey is nil or the Signer fails.
+type GenerateError struct {
+	KeyID  string
+	TeamID string
+
+	// Err is the error returned by Generate.
+	Err error
+}
+
+func (e *GenerateError) Error() string {
+	return fmt.Sprintf("token: failed to generate token for key %q: %v", e.KeyID, e.Err)
+}
+
+// Unwrap returns the error returned by Generate.
+func (e *GenerateError) Unwrap() error {
+	return e.Err
 }
 
 // AuthKeyFromFile loads a .p8 certificate from a local file and returns a
@@ -65,7 +99,8 @@ func AuthKeyFromBytes(bytes []byte) (*ecdsa.PrivateKey, error) {
 }
 
 // GenerateIfExpired checks to see if the token is about to expire and
-// generates a new token.
+// generates a new token. If a new token cannot be generated, the previous
+// bearer is returned. Use GenerateIfExpiredWithError to detect failures.
 func (t *Token) GenerateIfExpired() (bearer string) {
 	t.Lock()
 	defer t.Unlock()
@@ -75,17 +110,42 @@ func (t *Token) GenerateIfExpired() (bearer string) {
 	return t.Bearer
 }
 
+// GenerateIfExpiredWithError checks to see if the token is about to expire
+// and generates a new token. If a new token cannot be generated, it returns a
+// *GenerateError rather than the expired bearer.
+func (t *Token) GenerateIfExpiredWithError() (bearer string, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.Expired() {
+		if _, err := t.Generate(); err != nil {
+			return "", &GenerateError{KeyID: t.KeyID, TeamID: t.TeamID, Err: err}
+		}
+	}
+	return t.Bearer, nil
+}
+
 // Expired checks to see if the token has expired.
 func (t *Token) Expired() bool {
-	return time.Now().Unix() >= (t.IssuedAt + TokenTimeout)
+	return t.now().Unix() >= (t.IssuedAt + TokenTimeout)
+}
+
+// Age returns the time since the current bearer was generated, or zero if no
+// bearer has been generated.
+func (t *Token) Age() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	if t.IssuedAt == 0 {
+		return 0
+	}
+	return t.now().Sub(time.Unix(t.IssuedAt, 0))
 }
 
 // Generate creates a new token.
 func (t *Token) Generate() (bool, error) {
-	if t.AuthKey == nil {
+	if t.AuthKey == nil && t.Signer == nil {
 		return false, ErrAuthKeyNil
 	}
-	issuedAt := time.Now().Unix()
+	issuedAt := t.now().Unix()
 	jwtToken := &jwt.Token{
 		Header: map[string]interface{}{
 			"alg": "ES256",
@@ -97,7 +157,13 @@ func (t *Token) Generate() (bool, error) {
 		},
 		Method: jwt.SigningMethodES256,
 	}
-	bearer, err := jwtToken.SignedString(t.AuthKey)
+	var bearer string
+	var err error
+	if t.Signer != nil {
+		bearer, err = signedString(jwtToken, t.Signer)
+	} else {
+		bearer, err = jwtToken.SignedString(t.AuthKey)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -105,3 +171,10 @@ func (t *Token) Generate() (bool, error) {
 	t.Bearer = bearer
 	return true, nil
 }
+
+func (t *Token) now() time.Time {
+	if t.Clock == nil {
+		return clock.Real.Now()
+	}
+	return t.Clock.Now()
+}
@@ -1,14 +1,18 @@
 package token_test
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"errors"
+	"io"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sideshow/apns2/apns2test"
 	"github.com/sideshow/apns2/token"
 	"github.com/stretchr/testify/assert"
 )
@@ -68,6 +72,30 @@ func TestExpiresBeforeAnHour(t *testing.T) {
 	assert.True(t, token.Expired())
 }
 
+func TestExpiredWithClock(t *testing.T) {
+	clock := apns2test.NewFakeClock(time.Unix(1600000000, 0))
+	tok := &token.Token{IssuedAt: 1600000000, Clock: clock}
+	assert.False(t, tok.Expired())
+	clock.Advance((token.TokenTimeout - 1) * time.Second)
+	assert.False(t, tok.Expired())
+	clock.Advance(time.Second)
+	assert.True(t, tok.Expired())
+}
+
+func TestGenerateWithClock(t *testing.T) {
+	authKey, _ := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
+	clock := apns2test.NewFakeClock(time.Unix(1600000000, 0))
+	tok := &token.Token{AuthKey: authKey, Clock: clock}
+	first := tok.GenerateIfExpired()
+	assert.Equal(t, int64(1600000000), tok.IssuedAt)
+	clock.Advance(10 * time.Minute)
+	assert.Equal(t, 10*time.Minute, tok.Age())
+	assert.Equal(t, first, tok.GenerateIfExpired())
+	clock.Advance(token.TokenTimeout * time.Second)
+	assert.NotEqual(t, first, tok.GenerateIfExpired())
+	assert.Equal(t, clock.Now().Unix(), tok.IssuedAt)
+}
+
 func TestGenerateIfExpired(t *testing.T) {
 	authKey, _ := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
 	token := &token.Token{
@@ -77,6 +105,26 @@ func TestGenerateIfExpired(t *testing.T) {
 	assert.Equal(t, time.Now().Unix(), token.IssuedAt)
 }
 
+func TestGenerateIfExpiredWithError(t *testing.T) {
+	authKey, _ := token.AuthKeyFromFile("_fixtures/authkey-valid.p8")
+	token := &token.Token{
+		AuthKey: authKey,
+	}
+	bearer, err := token.GenerateIfExpiredWithError()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, bearer)
+	assert.Equal(t, token.Bearer, bearer)
+}
+
+func TestGenerateIfExpiredWithErrorNoAuthKey(t *testing.T) {
+	tok := &token.Token{KeyID: "ABC123DEFG", Bearer: "expired"}
+	bearer, err := tok.GenerateIfExpiredWithError()
+	assert.Equal(t, "", bearer)
+	assert.EqualError(t, err, `token: failed to generate token for key "ABC123DEFG": token: AuthKey was nil`)
+	assert.True(t, errors.Is(err, token.ErrAuthKeyNil))
+	assert.Equal(t, "expired", tok.GenerateIfExpired())
+}
+
 func TestGenerateWithNoAuthKey(t *testing.T) {
 	token := &token.Token{}
 	bool, err := token.Generate()
@@ -94,3 +142,61 @@ func TestGenerateWithInvalidAuthKey(t *testing.T) {
 	assert.False(t, bool)
 	assert.Error(t, err)
 }
+
+// Signer
+
+type mockSigner struct {
+	crypto.Signer
+	signature []byte
+	err       error
+}
+
+func (s *mockSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.signature != nil || s.err != nil {
+		return s.signature, s.err
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestGenerateWithSigner(t *testing.T) {
+	privatekey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tok := &token.Token{
+		Signer: &mockSigner{Signer: privatekey},
+		KeyID:  "ABC123DEFG",
+		TeamID: "DEF123GHIJ",
+	}
+	ok, err := tok.Generate()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	parsed, err := jwt.Parse(tok.Bearer, func(*jwt.Token) (interface{}, error) {
+		return &privatekey.PublicKey, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ES256", parsed.Header["alg"])
+	assert.Equal(t, "ABC123DEFG", parsed.Header["kid"])
+	assert.Equal(t, "DEF123GHIJ", parsed.Claims.(jwt.MapClaims)["iss"])
+}
+
+func TestGenerateWithSignerNotP256(t *testing.T) {
+	privatekey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	tok := &token.Token{Signer: privatekey}
+	ok, err := tok.Generate()
+	assert.False(t, ok)
+	assert.Equal(t, "", tok.Bearer)
+	assert.Equal(t, token.ErrSignerNotP256, err)
+}
+
+func TestGenerateWithSignerError(t *testing.T) {
+	privatekey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tok := &token.Token{Signer: &mockSigner{Signer: privatekey, err: errors.New("kms unavailable")}}
+	_, err := tok.Generate()
+	assert.EqualError(t, err, "kms unavailable")
+}
+
+func TestGenerateWithSignerInvalidSignature(t *testing.T) {
+	privatekey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tok := &token.Token{Signer: &mockSigner{Signer: privatekey, signature: []byte{0x30, 0x00}}}
+	_, err := tok.Generate()
+	assert.Error(t, err)
+}